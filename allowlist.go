@@ -0,0 +1,323 @@
+package git
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ipRangesTTL is how long published ip ranges are used
+	// before they are refreshed.
+	ipRangesTTL = time.Hour * 24
+
+	// ipRangesRetry is the time to wait after a failed refresh.
+	ipRangesRetry = time.Hour
+
+	// ipRangesTimeout is the timeout of a refresh.
+	ipRangesTimeout = time.Second * 30
+)
+
+// IPAllowlist is the list of addresses allowed to send webhooks.
+type IPAllowlist struct {
+	Nets    []net.IPNet // allowed networks
+	Sources []string    // names of published ip ranges e.g. github
+}
+
+// empty checks if the list is empty, i.e. allows all addresses.
+func (a IPAllowlist) empty() bool {
+	return len(a.Nets) == 0 && len(a.Sources) == 0
+}
+
+// allows checks if a request from remoteAddr is allowed. Published
+// ip ranges that are not known yet allow no address.
+func (a IPAllowlist) allows(remoteAddr string) bool {
+	if a.empty() {
+		return true
+	}
+	ip := net.ParseIP(hostOnly(remoteAddr))
+	if ip == nil {
+		return false
+	}
+	for _, n := range a.Nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	for _, name := range a.Sources {
+		for _, n := range ipSources[name].ranges() {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hookIPSources returns the names of the published ip ranges webhooks
+// of hook are checked against. Without allowlist, these are Atlassian's
+// ranges for Bitbucket Cloud, which may also be detected automatically.
+func hookIPSources(hook HookConfig) []string {
+	if !hook.AllowIPs.empty() {
+		return hook.AllowIPs.Sources
+	}
+	if hook.Type == "bitbucket" || hook.Type == "" {
+		return []string{"atlassian"}
+	}
+	return nil
+}
+
+// add adds arg to the list. arg is an address, a network in CIDR
+// notation, the name of published ip ranges or a file with one
+// address or network per line.
+func (a *IPAllowlist) add(arg string) error {
+	if _, ok := ipSources[arg]; ok {
+		a.Sources = append(a.Sources, arg)
+		return nil
+	}
+	if n, err := parseIPNet(arg); err == nil {
+		a.Nets = append(a.Nets, n)
+		return nil
+	}
+
+	file, err := os.Open(arg)
+	if err != nil {
+		return fmt.Errorf("%v is neither an address, network nor readable file", arg)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		n, err := parseIPNet(line)
+		if err != nil {
+			return fmt.Errorf("%v: %v", arg, err)
+		}
+		a.Nets = append(a.Nets, n)
+	}
+	return scanner.Err()
+}
+
+// parseIPNet parses an address or a network in CIDR notation.
+func parseIPNet(s string) (net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return net.IPNet{}, fmt.Errorf("invalid network %v", s)
+		}
+		return *n, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return net.IPNet{}, fmt.Errorf("invalid address %v", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// hostOnly returns the host of remoteAddr without port.
+func hostOnly(remoteAddr string) string {
+	host, _, _ := net.SplitHostPort(remoteAddr)
+	if host == "" {
+		return remoteAddr
+	}
+	return host
+}
+
+// ipSources are the ip ranges published by providers.
+var ipSources = map[string]*ipSource{
+	"atlassian": {
+		name:  "atlassian",
+		url:   "https://ip-ranges.atlassian.com/",
+		parse: parseAtlassianIPs,
+	},
+	"github": {
+		name:  "github",
+		url:   "https://api.github.com/meta",
+		parse: parseGithubIPs,
+	},
+}
+
+// ipCacheDir returns the directory ip ranges are cached in.
+var ipCacheDir = func() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "caddy-git")
+}
+
+// ipSource is a list of ip ranges published by a provider. The ranges
+// are refreshed in background and cached in a local file, so they are
+// known right away after a restart.
+type ipSource struct {
+	name  string
+	url   string
+	parse func(body []byte) ([]string, error)
+
+	nets     []net.IPNet
+	updated  time.Time // time the ranges were fetched
+	attempt  time.Time // time of the last refresh
+	loaded   bool      // the cache file was read
+	fetching bool
+	sync.Mutex
+}
+
+// ipCache is the content of the cache file of an ipSource.
+type ipCache struct {
+	Updated time.Time `json:"updated"`
+	Ranges  []string  `json:"ranges"`
+}
+
+// ranges returns the known ranges. The cache file is read on first
+// use and outdated ranges are refreshed in background.
+func (s *ipSource) ranges() []net.IPNet {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.loaded {
+		s.loaded = true
+		if err := s.load(); err != nil && !os.IsNotExist(err) {
+			Logger().Printf("[ERROR] Reading cached %v IP ranges: %v\n", s.name, err)
+		}
+	}
+	now := time.Now()
+	if !s.fetching && now.Sub(s.updated) > ipRangesTTL && now.Sub(s.attempt) > ipRangesRetry {
+		s.fetching, s.attempt = true, now
+		go s.refresh()
+	}
+	return s.nets
+}
+
+// cacheFile returns the path of the cache file.
+func (s *ipSource) cacheFile() string {
+	return filepath.Join(ipCacheDir(), s.name+"-ip-ranges.json")
+}
+
+// load reads the ranges from the cache file. s must be locked.
+func (s *ipSource) load() error {
+	body, err := ioutil.ReadFile(s.cacheFile())
+	if err != nil {
+		return err
+	}
+	var cache ipCache
+	if err := json.Unmarshal(body, &cache); err != nil {
+		return err
+	}
+	nets, err := parseIPNets(cache.Ranges)
+	if err != nil {
+		return err
+	}
+	s.nets, s.updated = nets, cache.Updated
+	return nil
+}
+
+// refresh fetches the ranges and writes them to the cache file.
+func (s *ipSource) refresh() {
+	ranges, err := s.fetch()
+	var nets []net.IPNet
+	if err == nil {
+		nets, err = parseIPNets(ranges)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.fetching = false
+	if err != nil {
+		Logger().Printf("[ERROR] Fetching %v IP ranges: %v\n", s.name, err)
+		return
+	}
+	s.nets, s.updated = nets, time.Now()
+
+	body, err := json.Marshal(ipCache{Updated: s.updated, Ranges: ranges})
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(s.cacheFile()), 0700)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(s.cacheFile(), body, 0600)
+	}
+	if err != nil {
+		Logger().Printf("[ERROR] Caching %v IP ranges: %v\n", s.name, err)
+	}
+}
+
+// fetch requests the published ranges.
+func (s *ipSource) fetch() ([]string, error) {
+	client := http.Client{Timeout: ipRangesTimeout}
+	resp, err := client.Get(s.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return s.parse(body)
+}
+
+// parseIPNets parses addresses and networks in CIDR notation.
+func parseIPNets(ranges []string) ([]net.IPNet, error) {
+	var nets []net.IPNet
+	for _, r := range ranges {
+		n, err := parseIPNet(r)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+type atlassianIPResponse struct {
+	CreationDate string             `json:"creationDate"`
+	SyncToken    int                `json:"syncToken"`
+	Items        []atlassianIPRange `json:"items"`
+}
+
+type atlassianIPRange struct {
+	Network string `json:"network"`
+	MaskLen int    `json:"mask_len"`
+	CIDR    string `json:"cidr"`
+	Mask    string `json:"mask"`
+}
+
+// parseAtlassianIPs parses the ip ranges of Atlassian.
+func parseAtlassianIPs(body []byte) ([]string, error) {
+	var resp atlassianIPResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	var ranges []string
+	for _, item := range resp.Items {
+		ranges = append(ranges, item.CIDR)
+	}
+	return ranges, nil
+}
+
+// parseGithubIPs parses the ip ranges GitHub sends webhooks from.
+func parseGithubIPs(body []byte) ([]string, error) {
+	var meta struct {
+		Hooks []string `json:"hooks"`
+	}
+	if err := json.Unmarshal(body, &meta); err != nil {
+		return nil, err
+	}
+	return meta.Hooks, nil
+}
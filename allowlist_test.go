@@ -0,0 +1,133 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIPAllowlist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "allowlist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "ips")
+	if err := ioutil.WriteFile(file, []byte("# office\n10.1.0.0/16\n\n2001:db8::1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	invalid := filepath.Join(dir, "invalid")
+	if err := ioutil.WriteFile(invalid, []byte("10.1.0.0/16\n10.1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var list IPAllowlist
+	for _, arg := range []string{"192.168.1.1", "172.16.0.0/12", file} {
+		if err := list.add(arg); err != nil {
+			t.Fatalf("Could not add %v: %v", arg, err)
+		}
+	}
+	for _, arg := range []string{"10.0.0.0/33", "300.1.1.1", filepath.Join(dir, "missing"), invalid} {
+		if err := (&IPAllowlist{}).add(arg); err == nil {
+			t.Errorf("Expected %v to be invalid", arg)
+		}
+	}
+
+	for i, test := range []struct {
+		addr    string
+		allowed bool
+	}{
+		{"192.168.1.1:1234", true},
+		{"192.168.1.2:1234", false},
+		{"172.20.1.1:1234", true},
+		{"10.1.2.3", true},
+		{"10.2.2.3", false},
+		{"[2001:db8::1]:1234", true},
+		{"[2001:db8::2]:1234", false},
+		{"invalid", false},
+	} {
+		if allowed := list.allows(test.addr); allowed != test.allowed {
+			t.Errorf("Test %v: Expected %v to be allowed %v", i, test.addr, test.allowed)
+		}
+	}
+	if !(IPAllowlist{}).allows("192.168.1.2:1234") {
+		t.Errorf("Expected empty allowlist to allow all addresses")
+	}
+
+	// requests from other addresses are rejected for all handlers
+	repo := &Repo{Branch: "master", Hook: HookConfig{URL: "/hook", AllowIPs: list}, stopped: true}
+	req, err := http.NewRequest("POST", "/hook", bytes.NewBufferString(`{"ref": "refs/heads/master"}`))
+	if err != nil {
+		t.Fatalf("Could not create HTTP request: %v", err)
+	}
+	req.RemoteAddr = "192.168.1.2:1234"
+	if code, _ := handleHook(httptest.NewRecorder(), req, repo, GenericHook{}); code != http.StatusForbidden {
+		t.Errorf("Expected status 403 but found %v", code)
+	}
+	req.RemoteAddr = "192.168.1.1:1234"
+	if code, err := handleHook(httptest.NewRecorder(), req, repo, GenericHook{}); code != http.StatusOK {
+		t.Errorf("Expected status 200 but found %v %v", code, err)
+	}
+}
+
+func TestHookIPSources(t *testing.T) {
+	for i, test := range []struct {
+		hook     HookConfig
+		expected []string
+	}{
+		{HookConfig{}, []string{"atlassian"}},
+		{HookConfig{Type: "bitbucket"}, []string{"atlassian"}},
+		{HookConfig{Type: "github"}, nil},
+		{HookConfig{Type: "bitbucket-server"}, nil},
+		{HookConfig{AllowIPs: IPAllowlist{Sources: []string{"github"}}}, []string{"github"}},
+		{HookConfig{Type: "bitbucket", AllowIPs: IPAllowlist{Nets: []net.IPNet{{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(8, 32)}}}}, nil},
+	} {
+		if sources := hookIPSources(test.hook); !reflect.DeepEqual(sources, test.expected) {
+			t.Errorf("Test %v: Expected %v, found %v", i, test.expected, sources)
+		}
+	}
+}
+
+func TestIPSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cacheDir := ipCacheDir
+	ipCacheDir = func() string { return dir }
+	defer func() { ipCacheDir = cacheDir }()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"hooks": ["192.30.252.0/22", "2a0a:a440::/29"]}`))
+	}))
+	defer server.Close()
+
+	// ranges are fetched in background
+	source := &ipSource{name: "test", url: server.URL, parse: parseGithubIPs}
+	if nets := source.ranges(); len(nets) != 0 {
+		t.Errorf("Expected no ranges before fetch, found %v", nets)
+	}
+	for i := 0; len(source.ranges()) == 0; i++ {
+		if i > 100 {
+			t.Fatalf("Expected ranges to be fetched")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// and read from the cache file, written along with them, after a restart
+	cached := &ipSource{name: "test", url: "http://invalid.invalid", parse: parseGithubIPs}
+	if nets := cached.ranges(); len(nets) != 2 || nets[0].String() != "192.30.252.0/22" {
+		t.Errorf("Expected cached ranges, found %v", nets)
+	}
+	if cached.fetching {
+		t.Errorf("Expected cached ranges not to be refreshed")
+	}
+}
@@ -0,0 +1,151 @@
+package git
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// AzureHook is the webhook for service hooks of Azure Repos.
+type AzureHook struct{}
+
+// azurePublisher is the publisherId of Azure DevOps payloads.
+const azurePublisher = "tfs"
+
+type azurePush struct {
+	EventType   string `json:"eventType"`
+	PublisherID string `json:"publisherId"`
+	Resource    struct {
+		RefUpdates []struct {
+			Name        string `json:"name"`
+			OldObjectID string `json:"oldObjectId"`
+			NewObjectID string `json:"newObjectId"`
+		} `json:"refUpdates"`
+		Commits []struct {
+			CommitID string `json:"commitId"`
+			Comment  string `json:"comment"`
+			Author   struct {
+				Name string `json:"name"`
+			} `json:"author"`
+		} `json:"commits"`
+		Repository struct {
+			Name      string `json:"name"`
+			RemoteURL string `json:"remoteUrl"`
+			SSHURL    string `json:"sshUrl"`
+			WebURL    string `json:"webUrl"`
+			Project   struct {
+				Name string `json:"name"`
+			} `json:"project"`
+		} `json:"repository"`
+		PushedBy struct {
+			DisplayName string `json:"displayName"`
+			UniqueName  string `json:"uniqueName"`
+		} `json:"pushedBy"`
+	} `json:"resource"`
+}
+
+// DoesHandle satisfies hookHandler. Azure sends no header
+// to identify it, it is detected by DoesHandlePayload.
+func (a AzureHook) DoesHandle(h http.Header) bool {
+	return true
+}
+
+// DoesHandlePayload satisfies payloadDetector.
+func (a AzureHook) DoesHandlePayload(body []byte) bool {
+	var push azurePush
+	if err := json.Unmarshal(body, &push); err != nil {
+		return false
+	}
+	return push.PublisherID == azurePublisher
+}
+
+// Handle satisfies hookHandler.
+func (a AzureHook) Handle(w http.ResponseWriter, r *http.Request, repo *Repo) (int, error) {
+	if r.Method != "POST" {
+		return http.StatusMethodNotAllowed, errors.New("the request had an invalid method")
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return http.StatusRequestTimeout, errors.New("could not read body from request")
+	}
+
+	err = a.handleBasic(r, repo.Hook)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+
+	var push azurePush
+	if err := json.Unmarshal(body, &push); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	switch push.EventType {
+	case "git.push":
+		err = a.handlePush(push, repo)
+		if !hookIgnored(err) && err != nil {
+			return http.StatusBadRequest, err
+		}
+
+	// return 400 if we do not handle the event type.
+	default:
+		return http.StatusBadRequest, fmt.Errorf("unsupported event type '%v'", push.EventType)
+	}
+
+	return http.StatusOK, err
+}
+
+// handleBasic checks for basic auth in the request. Azure service hooks
+// send the secret as password with any user name. If it exists, verify
+// that it matches the secret in the Caddy configuration. It is only
+// required if hook requires authentication.
+func (a AzureHook) handleBasic(r *http.Request, hook HookConfig) error {
+	_, password, ok := r.BasicAuth()
+	if !ok {
+		if hook.RequireAuth {
+			return errors.New("basic auth is required but was missing")
+		}
+		return nil
+	}
+	if hook.Secret == "" {
+		Logger().Println("unable to verify request. Secret not set in caddyfile")
+		return nil
+	}
+	return checkToken(password, hook.Secret)
+}
+
+func (a AzureHook) handlePush(push azurePush, repo *Repo) error {
+	if len(push.Resource.RefUpdates) == 0 {
+		return errors.New("the push was incomplete, missing ref updates")
+	}
+	return hookDispatch(a, repo, push.events()...)
+}
+
+// events returns a hook event for each ref update of the push.
+func (p azurePush) events() []HookEvent {
+	res := p.Resource
+	hookRepo := HookRepo{URLs: nonEmpty(res.Repository.RemoteURL, res.Repository.SSHURL, res.Repository.WebURL)}
+	if res.Repository.Project.Name != "" && res.Repository.Name != "" {
+		hookRepo.Name = res.Repository.Project.Name + "/" + res.Repository.Name
+	}
+	pusher := res.PushedBy.UniqueName
+	if pusher == "" {
+		pusher = res.PushedBy.DisplayName
+	}
+
+	var events []HookEvent
+	for _, update := range res.RefUpdates {
+		e := newHookEvent(update.Name)
+		e.Before, e.After = update.OldObjectID, update.NewObjectID
+		e.Deleted = update.NewObjectID == zeroSHA
+		e.Repo = hookRepo
+		e.Pusher = pusher
+		for _, c := range res.Commits {
+			e.Commits = append(e.Commits, HookCommit{ID: c.CommitID, Message: c.Comment, Author: c.Author.Name})
+		}
+		events = append(events, e)
+	}
+	return events
+}
@@ -0,0 +1,109 @@
+package git
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAzureDeployPush(t *testing.T) {
+	repo := &Repo{
+		URL:    "https://dev.azure.com/org/project/_git/repo",
+		Branch: "feature/x",
+		Hook:   HookConfig{URL: "/azure_deploy", Secret: "secret", RequireAuth: true},
+	}
+	azHook := AzureHook{}
+
+	for i, test := range []struct {
+		body     string
+		password string
+		code     int
+	}{
+		{"", "secret", 400},
+		{pushAZBody, "", 401},
+		{pushAZBody, "wrong", 401},
+		{pushAZBody, "secret", 200},
+		{pushAZBodyOther, "secret", 200},
+		{pushAZBodyEmpty, "secret", 400},
+		{`{"eventType": "git.pullrequest.created", "publisherId": "tfs"}`, "secret", 400},
+	} {
+		req, err := http.NewRequest("POST", "/azure_deploy", bytes.NewBuffer([]byte(test.body)))
+		if err != nil {
+			t.Fatalf("Test %v: Could not create HTTP request: %v", i, err)
+		}
+		if test.password != "" {
+			req.SetBasicAuth("azure", test.password)
+		}
+
+		rec := httptest.NewRecorder()
+
+		code, err := azHook.Handle(rec, req, repo)
+
+		if code != test.code {
+			t.Errorf("Test %d: Expected response code to be %d but was %d: %v", i, test.code, code, err)
+		}
+	}
+
+	// azure is detected by the payload
+	req, err := http.NewRequest("POST", "/azure_deploy", bytes.NewBuffer([]byte(pushAZBody)))
+	if err != nil {
+		t.Fatalf("Could not create HTTP request: %v", err)
+	}
+	if handler := handlerFor(repo, req); handler != azHook {
+		t.Errorf("Expected azure handler to be detected but found %v", handler)
+	}
+	if code, err := handlerFor(repo, req).Handle(httptest.NewRecorder(), req, &Repo{URL: repo.URL, Branch: "master"}); code != 200 || !hookIgnored(err) {
+		t.Errorf("Expected detected request to be handled but found %v %v", code, err)
+	}
+}
+
+var pushAZBody = `
+{
+  "eventType": "git.push",
+  "publisherId": "tfs",
+  "resource": {
+    "refUpdates": [
+      {
+        "name": "refs/heads/feature/x",
+        "oldObjectId": "aad331d8d3b131fa9ae03cf5e53965b51942618a",
+        "newObjectId": "33b55f7cb7e7e245323987634f960cf4a6e6bc74"
+      }
+    ],
+    "repository": {
+      "name": "repo",
+      "remoteUrl": "https://org@dev.azure.com/org/project/_git/repo",
+      "project": {
+        "name": "project"
+      }
+    },
+    "pushedBy": {
+      "uniqueName": "user@example.com"
+    }
+  }
+}
+`
+
+var pushAZBodyOther = `
+{
+  "eventType": "git.push",
+  "publisherId": "tfs",
+  "resource": {
+    "refUpdates": [
+      {
+        "name": "refs/heads/some-other-branch"
+      }
+    ]
+  }
+}
+`
+
+var pushAZBodyEmpty = `
+{
+  "eventType": "git.push",
+  "publisherId": "tfs",
+  "resource": {
+    "refUpdates": []
+  }
+}
+`
@@ -1,28 +1,53 @@
 package git
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io/ioutil"
-	"net"
 	"net/http"
 	"strings"
-	"sync"
-	"time"
 )
 
 // BitbucketHook is webhook for BitBucket.org.
 type BitbucketHook struct{}
 
+type bbRef struct {
+	Name   string `json:"name,omitempty"`
+	Type   string `json:"type,omitempty"`
+	Target struct {
+		Hash string `json:"hash,omitempty"`
+	} `json:"target,omitempty"`
+}
+
 type bbPush struct {
 	Push struct {
 		Changes []struct {
-			New struct {
-				Name string `json:"name,omitempty"`
-			} `json:"new,omitempty"`
+			New     *bbRef `json:"new,omitempty"`
+			Old     *bbRef `json:"old,omitempty"`
+			Forced  bool   `json:"forced,omitempty"`
+			Closed  bool   `json:"closed,omitempty"`
+			Commits []struct {
+				Hash    string `json:"hash,omitempty"`
+				Message string `json:"message,omitempty"`
+				Author  struct {
+					Raw string `json:"raw,omitempty"`
+				} `json:"author,omitempty"`
+			} `json:"commits,omitempty"`
 		} `json:"changes,omitempty"`
 	} `json:"push,omitempty"`
+	Repository struct {
+		FullName string `json:"full_name,omitempty"`
+		Links    struct {
+			HTML struct {
+				Href string `json:"href,omitempty"`
+			} `json:"html,omitempty"`
+		} `json:"links,omitempty"`
+	} `json:"repository,omitempty"`
+	Actor struct {
+		DisplayName string `json:"display_name,omitempty"`
+		Nickname    string `json:"nickname,omitempty"`
+	} `json:"actor,omitempty"`
 }
 
 // DoesHandle satisfies hookHandler.
@@ -38,7 +63,8 @@ func (b BitbucketHook) DoesHandle(h http.Header) bool {
 
 // Handle satisfies hookHandler.
 func (b BitbucketHook) Handle(w http.ResponseWriter, r *http.Request, repo *Repo) (int, error) {
-	if !b.verifyBitbucketIP(r.RemoteAddr) {
+	// the hook's allowlist replaces Atlassian's ip ranges
+	if repo.Hook.AllowIPs.empty() && !b.verifyBitbucketIP(r.RemoteAddr) {
 		return http.StatusForbidden, errors.New("the request doesn't come from a valid IP")
 	}
 
@@ -46,11 +72,17 @@ func (b BitbucketHook) Handle(w http.ResponseWriter, r *http.Request, repo *Repo
 		return http.StatusMethodNotAllowed, errors.New("the request had an invalid method")
 	}
 
+	// read full body - required for signature
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		return http.StatusRequestTimeout, errors.New("could not read body from request")
 	}
 
+	err = b.handleSignature(r, body, repo.Hook)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+
 	event := r.Header.Get("X-Event-Key")
 	if event == "" {
 		return http.StatusBadRequest, errors.New("the 'X-Event-Key' header is required but was missing")
@@ -70,6 +102,28 @@ func (b BitbucketHook) Handle(w http.ResponseWriter, r *http.Request, repo *Repo
 	return http.StatusOK, err
 }
 
+// handleSignature checks for the HMAC-SHA256 signature of the payload
+// in the request, prefixed by sha256=. If it exists, verify that it
+// matches the secret in the Caddy configuration. It is only required
+// if hook requires authentication.
+func (b BitbucketHook) handleSignature(r *http.Request, body []byte, hook HookConfig) error {
+	signature := r.Header.Get("X-Hub-Signature")
+	if signature == "" {
+		if hook.RequireAuth {
+			return errors.New("the 'X-Hub-Signature' header is required but was missing")
+		}
+		return nil
+	}
+	if hook.Secret == "" {
+		Logger().Println("unable to verify request. Secret not set in caddyfile")
+		return nil
+	}
+	if !strings.HasPrefix(signature, "sha256=") {
+		return errors.New("the 'X-Hub-Signature' header is malformed")
+	}
+	return checkHMAC(strings.TrimPrefix(signature, "sha256="), sha256.New, hook.Secret, body)
+}
+
 func (b BitbucketHook) handlePush(body []byte, repo *Repo) error {
 	var push bbPush
 
@@ -82,118 +136,51 @@ func (b BitbucketHook) handlePush(body []byte, repo *Repo) error {
 		return errors.New("the push was incomplete, missing change list")
 	}
 
-	change := push.Push.Changes[0]
-	if len(change.New.Name) == 0 {
-		return errors.New("the push didn't contain a valid branch name")
-	}
-
-	branch := change.New.Name
-	if branch != repo.Branch {
-		return hookIgnoredError{hookType: hookName(b), err: fmt.Errorf("found different branch %v", branch)}
-	}
-	Logger().Print("Received pull notification for the tracking branch, updating...\n")
-	repo.Pull()
-
-	return nil
+	return hookDispatch(b, repo, push.events()...)
 }
 
-func hostOnly(remoteAddr string) string {
-	host, _, _ := net.SplitHostPort(remoteAddr)
-	if host == "" {
-		return remoteAddr
-	}
-	return host
-}
-
-func (b BitbucketHook) verifyBitbucketIP(remoteAddr string) bool {
-	ipAddress := net.ParseIP(hostOnly(remoteAddr))
-
-	updateBitBucketIPs()
-
-	atlassianIPsMu.Lock()
-	ipItems := atlassianIPs.Items
-	atlassianIPsMu.Unlock()
-
-	// if there was a problem getting list of IPs, might as well
-	// allow it since it could still need authentication anyway
-	if len(ipItems) == 0 {
-		return true
-	}
-
-	for _, item := range ipItems {
-		// it may be regular ip address
-		if !strings.Contains(item.CIDR, "/") {
-			ip := net.ParseIP(item.CIDR)
-			if ip.Equal(ipAddress) {
-				return true
-			}
-			continue
+// events returns a hook event for each change of the push. Deleted
+// refs are reported by their old name.
+func (p bbPush) events() []HookEvent {
+	var events []HookEvent
+	for _, change := range p.Push.Changes {
+		ref := change.New
+		if ref == nil {
+			ref = change.Old
 		}
-
-		_, cidrnet, err := net.ParseCIDR(item.CIDR)
-		if err != nil {
-			Logger().Printf("Error parsing CIDR block [%s]. Skipping...\n", item.CIDR)
-			continue
+		if ref == nil {
+			ref = &bbRef{}
 		}
-
-		if cidrnet.Contains(ipAddress) {
-			return true
+		prefix := "refs/heads/"
+		if ref.Type == "tag" {
+			prefix = "refs/tags/"
 		}
-	}
-
-	return false
-}
-
-func updateBitBucketIPs() {
-	atlassianIPsMu.Lock()
-	defer atlassianIPsMu.Unlock()
-
-	// if list of IP ranges is outdated, get latest
-	if atlassianIPs.lastUpdated.IsZero() ||
-		time.Since(atlassianIPs.lastUpdated) > 24*time.Hour {
 
-		resp, err := http.Get("https://ip-ranges.atlassian.com/")
-		if err != nil {
-			// allow, since we can't know for sure either way, I guess
-			Logger().Printf("[ERROR] Requesting recent IPs for bitbucket: %v", err)
-			return
+		e := newHookEvent(prefix + ref.Name)
+		if change.Old != nil {
+			e.Before = change.Old.Target.Hash
 		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			Logger().Printf("[ERROR] Getting recent IPs for bitbucket: HTTP %d", resp.StatusCode)
-			return
+		if change.New != nil {
+			e.After = change.New.Target.Hash
 		}
-
-		var newIPs atlassianIPResponse
-		err = json.NewDecoder(resp.Body).Decode(&newIPs)
-		if err != nil {
-			Logger().Printf("[ERROR] Decoding recent IPs for bitbucket: %v", err)
-			return
+		e.Forced = change.Forced
+		e.Deleted = change.Closed || change.New == nil
+		e.Repo = HookRepo{Name: p.Repository.FullName, URLs: nonEmpty(p.Repository.Links.HTML.Href)}
+		e.Pusher = p.Actor.Nickname
+		if e.Pusher == "" {
+			e.Pusher = p.Actor.DisplayName
 		}
-
-		// replace the IP list
-		newIPs.lastUpdated = time.Now()
-		atlassianIPs = newIPs
+		for _, c := range change.Commits {
+			e.Commits = append(e.Commits, HookCommit{ID: c.Hash, Message: c.Message, Author: c.Author.Raw})
+		}
+		events = append(events, e)
 	}
+	return events
 }
 
-type atlassianIPResponse struct {
-	CreationDate string             `json:"creationDate"`
-	SyncToken    int                `json:"syncToken"`
-	Items        []atlassianIPRange `json:"items"`
-
-	lastUpdated time.Time // added by us
-}
-
-type atlassianIPRange struct {
-	Network string `json:"network"`
-	MaskLen int    `json:"mask_len"`
-	CIDR    string `json:"cidr"`
-	Mask    string `json:"mask"`
+// verifyBitbucketIP checks that remoteAddr is one of Atlassian's ip
+// ranges, which are fetched in background. No address is allowed
+// until the ranges are known.
+func (b BitbucketHook) verifyBitbucketIP(remoteAddr string) bool {
+	return IPAllowlist{Sources: []string{"atlassian"}}.allows(remoteAddr)
 }
-
-var (
-	atlassianIPs   atlassianIPResponse
-	atlassianIPsMu sync.Mutex
-)
@@ -2,6 +2,10 @@ package git
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -13,25 +17,11 @@ func TestBitbucketDeployPush(t *testing.T) {
 	bbHook := BitbucketHook{}
 
 	remoteIP := "18.246.31.128"
-	atlassianIPsMu.Lock()
-	atlassianIPs = atlassianIPResponse{
-		Items: []atlassianIPRange{
-			{
-				Network: remoteIP,
-				MaskLen: 25,
-				CIDR:    remoteIP + "/25",
-				Mask:    "255.255.255.128",
-			},
-			{
-				Network: "2600:1f18:2146:e306:939f:d1b3:aa36:ac42",
-				MaskLen: 56,
-				CIDR:    "2600:1f18:2146:e300::/56",
-				Mask:    "ffff:ffff:ffff:ff00::",
-			},
-		},
-		lastUpdated: time.Now(),
-	}
-	atlassianIPsMu.Unlock()
+	source := ipSources["atlassian"]
+	source.Lock()
+	source.nets, _ = parseIPNets([]string{remoteIP + "/25", "2600:1f18:2146:e300::/56"})
+	source.updated, source.loaded = time.Now(), true
+	source.Unlock()
 
 	for i, test := range []struct {
 		ip           string
@@ -73,6 +63,79 @@ func TestBitbucketDeployPush(t *testing.T) {
 		}
 	}
 
+	// no address is allowed until the ranges are known
+	source.Lock()
+	nets := source.nets
+	source.nets = nil
+	source.Unlock()
+	req, err := http.NewRequest("POST", "/bitbucket_deploy", bytes.NewBuffer([]byte(pushBBBodyValid)))
+	if err != nil {
+		t.Fatalf("Could not create HTTP request: %v", err)
+	}
+	req.RemoteAddr = remoteIP
+	req.Header.Add("X-Event-Key", "repo:push")
+	if code, _ := bbHook.Handle(httptest.NewRecorder(), req, repo); code != 403 {
+		t.Errorf("Expected response code to be 403 but was %d", code)
+	}
+	source.Lock()
+	source.nets = nets
+	source.Unlock()
+
+	// the hook's allowlist replaces Atlassian's ip ranges
+	repo.Hook.AllowIPs = IPAllowlist{Nets: []net.IPNet{{IP: net.IP{131, 103, 20, 160}, Mask: net.CIDRMask(32, 32)}}}
+	req, err = http.NewRequest("POST", "/bitbucket_deploy", bytes.NewBuffer([]byte(pushBBBodyValid)))
+	if err != nil {
+		t.Fatalf("Could not create HTTP request: %v", err)
+	}
+	req.RemoteAddr = "131.103.20.160"
+	req.Header.Add("X-Event-Key", "repo:push")
+	if code, err := bbHook.Handle(httptest.NewRecorder(), req, repo); code != 200 {
+		t.Errorf("Expected response code to be 200 but was %d: %v", code, err)
+	}
+}
+
+func TestBitbucketSignature(t *testing.T) {
+	remoteIP := "192.0.2.1"
+	repo := &Repo{Branch: "develop", Hook: HookConfig{
+		URL:         "/bitbucket_deploy",
+		Secret:      "secret",
+		RequireAuth: true,
+		AllowIPs:    IPAllowlist{Nets: []net.IPNet{{IP: net.ParseIP(remoteIP), Mask: net.CIDRMask(128, 128)}}},
+	}}
+	sign := func(secret string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(pushBBBodyValid))
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	for i, test := range []struct {
+		signature string
+		code      int
+	}{
+		{"", http.StatusUnauthorized},
+		{sign("wrong"), http.StatusUnauthorized},
+		{"sha1=" + sign("secret")[7:], http.StatusUnauthorized},
+		{sign("secret"), http.StatusOK},
+	} {
+		req, err := http.NewRequest("POST", "/bitbucket_deploy", bytes.NewBufferString(pushBBBodyValid))
+		if err != nil {
+			t.Fatalf("Test %v: Could not create HTTP request: %v", i, err)
+		}
+		req.RemoteAddr = remoteIP + ":1234"
+		req.Header.Set("X-Event-Key", "repo:push")
+		if test.signature != "" {
+			req.Header.Set("X-Hub-Signature", test.signature)
+		}
+
+		handler := handlerFor(repo, req)
+		if _, ok := handler.(BitbucketHook); !ok {
+			t.Fatalf("Test %v: Expected Bitbucket handler, found %T", i, handler)
+		}
+		rec := httptest.NewRecorder()
+		if code, _ := serveHook(rec, req, repo, handler); code != test.code {
+			t.Errorf("Test %v: Expected response code to be %v but was %v", i, test.code, code)
+		}
+	}
 }
 
 var pushBBBodyEmptyBranch = `
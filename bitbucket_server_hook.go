@@ -0,0 +1,167 @@
+package git
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// BitbucketServerHook is the webhook for Bitbucket Server and Data Center.
+type BitbucketServerHook struct{}
+
+type bbsPush struct {
+	Actor struct {
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	} `json:"actor"`
+	Repository struct {
+		Slug    string `json:"slug"`
+		Project struct {
+			Key string `json:"key"`
+		} `json:"project"`
+		Links struct {
+			Clone []struct {
+				Href string `json:"href"`
+			} `json:"clone"`
+			Self []struct {
+				Href string `json:"href"`
+			} `json:"self"`
+		} `json:"links"`
+	} `json:"repository"`
+	Changes []struct {
+		Ref struct {
+			ID        string `json:"id"`
+			DisplayID string `json:"displayId"`
+			Type      string `json:"type"`
+		} `json:"ref"`
+		FromHash string `json:"fromHash"`
+		ToHash   string `json:"toHash"`
+		Type     string `json:"type"`
+	} `json:"changes"`
+}
+
+// DoesHandle satisfies hookHandler.
+func (b BitbucketServerHook) DoesHandle(h http.Header) bool {
+	event := h.Get("X-Event-Key")
+
+	// Bitbucket Cloud uses the same header with different event keys
+	return event == "repo:refs_changed" || event == "diagnostics:ping" || strings.HasPrefix(event, "pr:")
+}
+
+// Handle satisfies hookHandler.
+func (b BitbucketServerHook) Handle(w http.ResponseWriter, r *http.Request, repo *Repo) (int, error) {
+	if r.Method != "POST" {
+		return http.StatusMethodNotAllowed, errors.New("the request had an invalid method")
+	}
+
+	// read full body - required for signature
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return http.StatusRequestTimeout, errors.New("could not read body from request")
+	}
+
+	err = b.handleSignature(r, body, repo.Hook)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+
+	event := r.Header.Get("X-Event-Key")
+	if event == "" {
+		return http.StatusBadRequest, errors.New("the 'X-Event-Key' header is required but was missing")
+	}
+
+	switch event {
+	case "diagnostics:ping":
+		w.Write([]byte("pong"))
+	case "repo:refs_changed":
+		err = b.handlePush(body, repo)
+		if !hookIgnored(err) && err != nil {
+			return http.StatusBadRequest, err
+		}
+
+	// return 400 if we do not handle the event type.
+	default:
+		return http.StatusBadRequest, nil
+	}
+
+	return http.StatusOK, err
+}
+
+// handleSignature checks for the HMAC signature of the payload in the
+// request, prefixed by the algorithm e.g. sha256=. If it exists, verify
+// that it matches the secret in the Caddy configuration. It is only
+// required if hook requires authentication.
+func (b BitbucketServerHook) handleSignature(r *http.Request, body []byte, hook HookConfig) error {
+	signature := r.Header.Get("X-Hub-Signature")
+	if signature == "" {
+		if hook.RequireAuth {
+			return errors.New("the 'X-Hub-Signature' header is required but was missing")
+		}
+		return nil
+	}
+	if hook.Secret == "" {
+		Logger().Println("unable to verify request. Secret not set in caddyfile")
+		return nil
+	}
+	parts := strings.SplitN(signature, "=", 2)
+	hash, ok := hmacHashes[parts[0]]
+	if len(parts) != 2 || !ok {
+		return errors.New("the 'X-Hub-Signature' header is malformed")
+	}
+	return checkHMAC(parts[1], hash, hook.Secret, body)
+}
+
+func (b BitbucketServerHook) handlePush(body []byte, repo *Repo) error {
+	var push bbsPush
+
+	err := json.Unmarshal(body, &push)
+	if err != nil {
+		return err
+	}
+
+	if len(push.Changes) == 0 {
+		return errors.New("the push was incomplete, missing change list")
+	}
+
+	return hookDispatch(b, repo, push.events()...)
+}
+
+// events returns a hook event for each change of the push.
+func (p bbsPush) events() []HookEvent {
+	var hookRepo HookRepo
+	if p.Repository.Project.Key != "" && p.Repository.Slug != "" {
+		hookRepo.Name = p.Repository.Project.Key + "/" + p.Repository.Slug
+	}
+	for _, link := range p.Repository.Links.Clone {
+		hookRepo.URLs = append(hookRepo.URLs, nonEmpty(link.Href)...)
+	}
+	for _, link := range p.Repository.Links.Self {
+		hookRepo.URLs = append(hookRepo.URLs, nonEmpty(link.Href)...)
+	}
+	pusher := p.Actor.Name
+	if pusher == "" {
+		pusher = p.Actor.DisplayName
+	}
+
+	var events []HookEvent
+	for _, change := range p.Changes {
+		ref := change.Ref.ID
+		if ref == "" && change.Ref.DisplayID != "" {
+			// the display id is the plain branch or tag name
+			ref = "refs/heads/" + change.Ref.DisplayID
+			if change.Ref.Type == "TAG" {
+				ref = "refs/tags/" + change.Ref.DisplayID
+			}
+		}
+
+		e := newHookEvent(ref)
+		e.Before, e.After = change.FromHash, change.ToHash
+		e.Deleted = change.Type == "DELETE"
+		e.Repo = hookRepo
+		e.Pusher = pusher
+		events = append(events, e)
+	}
+	return events
+}
@@ -0,0 +1,136 @@
+package git
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBitbucketServerDeployPush(t *testing.T) {
+	repo := &Repo{
+		URL:     "https://bitbucket.example.com/scm/proj/repo.git",
+		Branch:  "release/1.0",
+		Hook:    HookConfig{URL: "/bitbucket_server_deploy"},
+		stopped: true,
+	}
+	bbsHook := BitbucketServerHook{}
+
+	for i, test := range []struct {
+		body         string
+		event        string
+		responseBody string
+		code         int
+	}{
+		{"", "", "", 400},
+		{"", "repo:refs_changed", "", 400},
+		{pushBBSBodyValid, "repo:refs_changed", "", 200},
+		{pushBBSBodyEmpty, "repo:refs_changed", "", 400},
+		{"", "diagnostics:ping", "pong", 200},
+		{"", "pr:opened", "", 400},
+	} {
+
+		req, err := http.NewRequest("POST", "/bitbucket_server_deploy", bytes.NewBuffer([]byte(test.body)))
+		if err != nil {
+			t.Fatalf("Test %v: Could not create HTTP request: %v", i, err)
+		}
+
+		if test.event != "" {
+			req.Header.Add("X-Event-Key", test.event)
+		}
+
+		rec := httptest.NewRecorder()
+
+		code, err := bbsHook.Handle(rec, req, repo)
+
+		if code != test.code {
+			t.Errorf("Test %d: Expected response code to be %d but was %d: %v", i, test.code, code, err)
+		}
+
+		if rec.Body.String() != test.responseBody {
+			t.Errorf("Test %d: Expected response body to be '%v' but was '%v'", i, test.responseBody, rec.Body.String())
+		}
+	}
+
+	// Bitbucket Cloud uses the same header
+	h := http.Header{}
+	h.Set("X-Event-Key", "repo:push")
+	if bbsHook.DoesHandle(h) {
+		t.Errorf("Expected Bitbucket Cloud push not to be handled")
+	}
+}
+
+var pushBBSBodyValid = `
+{
+  "eventKey": "repo:refs_changed",
+  "actor": {
+    "name": "admin"
+  },
+  "repository": {
+    "slug": "repo",
+    "project": {
+      "key": "PROJ"
+    },
+    "links": {
+      "clone": [
+        {"href": "ssh://git@bitbucket.example.com:7999/proj/repo.git", "name": "ssh"},
+        {"href": "https://bitbucket.example.com/scm/proj/repo.git", "name": "http"}
+      ]
+    }
+  },
+  "changes": [
+    {
+      "ref": {
+        "id": "refs/heads/release/1.0",
+        "displayId": "release/1.0",
+        "type": "BRANCH"
+      },
+      "fromHash": "ecddabb624f6f5ba43816f5926e580a5f680a932",
+      "toHash": "178864a7d521b6f5e720b386b2c2b0ef8563e0dc",
+      "type": "UPDATE"
+    }
+  ]
+}
+`
+
+var pushBBSBodyEmpty = `
+{
+  "eventKey": "repo:refs_changed",
+  "changes": []
+}
+`
+
+func TestBitbucketServerSignature(t *testing.T) {
+	body := []byte(`{"changes": []}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	for i, test := range []struct {
+		signature string
+		body      string
+		shouldErr bool
+	}{
+		{"sha256=" + signature, string(body), false},
+		{"sha256=" + signature, `{"changes": null}`, true},
+		{signature, string(body), true},
+		{"md5=" + signature, string(body), true},
+		{"", string(body), true},
+	} {
+		req, err := http.NewRequest("POST", "/bitbucket_server_deploy", nil)
+		if err != nil {
+			t.Fatalf("Test %v: Could not create HTTP request: %v", i, err)
+		}
+		if test.signature != "" {
+			req.Header.Set("X-Hub-Signature", test.signature)
+		}
+
+		err = BitbucketServerHook{}.handleSignature(req, []byte(test.body), HookConfig{Secret: "secret", RequireAuth: true})
+		if test.shouldErr != (err != nil) {
+			t.Errorf("Test %v: Expected error %v but found %v", i, test.shouldErr, err)
+		}
+	}
+}
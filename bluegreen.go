@@ -0,0 +1,152 @@
+package git
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/caddyserver/caddy/caddyhttp/proxy"
+)
+
+func init() {
+	// proxy / localhost:8081 localhost:8082 {
+	//     policy git name
+	// }
+	proxy.RegisterPolicy("git", func(name string) proxy.Policy {
+		return upstreamPolicy(name)
+	})
+}
+
+// upstreams stores the address of the active instance of each
+// blue/green then_long command by name.
+var upstreams = struct {
+	addresses map[string]string
+	sync.RWMutex
+}{addresses: make(map[string]string)}
+
+// Upstream returns the address of the active instance of the blue/green
+// then_long command with name, or an empty string if none is active.
+func Upstream(name string) string {
+	upstreams.RLock()
+	defer upstreams.RUnlock()
+	return upstreams.addresses[name]
+}
+
+// setUpstream publishes address as the upstream for name.
+func setUpstream(name, address string) {
+	upstreams.Lock()
+	upstreams.addresses[name] = address
+	upstreams.Unlock()
+}
+
+// clearUpstream removes the upstream for name if it is still address,
+// so stopping an instance does not clear the address published by a
+// newer one.
+func clearUpstream(name, address string) {
+	upstreams.Lock()
+	if upstreams.addresses[name] == address {
+		delete(upstreams.addresses, name)
+	}
+	upstreams.Unlock()
+}
+
+// upstreamPolicy is a proxy policy that selects the upstream host
+// published by the blue/green then_long command it is named after.
+type upstreamPolicy string
+
+// Select satisfies proxy.Policy.
+func (u upstreamPolicy) Select(pool proxy.HostPool, r *http.Request) *proxy.UpstreamHost {
+	address := Upstream(string(u))
+	if address == "" {
+		return nil
+	}
+	for _, host := range pool {
+		name := host.Name
+		if i := strings.Index(name, "://"); i >= 0 {
+			name = name[i+3:]
+		}
+		if (name == address || host.Name == address) && host.Available() {
+			return host
+		}
+	}
+	return nil
+}
+
+// blueGreen runs a then_long command as two instances listening on
+// alternate addresses. Only one of them is active at a time.
+type blueGreen struct {
+	name      string
+	addresses [2]string
+	instances [2]*gitCmd
+	active    int // index of the active instance, -1 if none
+}
+
+// execBlueGreen starts the inactive instance of the command and switches
+// the upstream over to it once it is ready. The previously active
+// instance is stopped afterwards.
+func (g *gitCmd) execBlueGreen(dir string) error {
+	g.Lock()
+	bg := g.blueGreen
+	prev, next := bg.active, 0
+	if prev == 0 {
+		next = 1
+	}
+	instance := g.instance(next)
+	g.Unlock()
+
+	if err := instance.Exec(dir); err != nil {
+		instance.haltProcess()
+		return err
+	}
+
+	g.Lock()
+	bg.active = next
+	g.Unlock()
+	setUpstream(bg.name, bg.addresses[next])
+	Logger().Printf("Upstream '%v' switched to %v.\n", bg.name, bg.addresses[next])
+
+	if prev >= 0 {
+		bg.instances[prev].haltProcess()
+	}
+	return nil
+}
+
+// instance returns the instance i of a blue/green command. The
+// placeholders {upstream} and {port} in the command, its arguments and
+// the readiness probe are replaced with the address of the instance.
+// If no readiness probe is set, the instance is ready once its address
+// accepts connections.
+func (g *gitCmd) instance(i int) *gitCmd {
+	bg := g.blueGreen
+	if bg.instances[i] != nil {
+		return bg.instances[i]
+	}
+
+	address := bg.addresses[i]
+	_, port, _ := net.SplitHostPort(address)
+	replacer := strings.NewReplacer(
+		"{upstream}", strings.TrimPrefix(address, "unix:"),
+		"{port}", port,
+	)
+
+	args := make([]string, len(g.args))
+	for j := range g.args {
+		args[j] = replacer.Replace(g.args[j])
+	}
+	instance := newLongCmd(replacer.Replace(g.command), args...)
+	instance.stopSignal = g.stopSignal
+	instance.stopTimeout = g.stopTimeout
+	instance.restart = g.restart
+	instance.log = g.log
+	instance.tailLines = g.tailLines
+	instance.ready = &readyProbe{kind: "tcp", address: address, timeout: defaultReadyTimeout}
+	if g.ready != nil {
+		ready := *g.ready
+		ready.address = replacer.Replace(ready.address)
+		instance.ready = &ready
+	}
+
+	bg.instances[i] = instance
+	return instance
+}
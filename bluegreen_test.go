@@ -0,0 +1,99 @@
+package git
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/abiosoft/caddy-git/gittest"
+	"github.com/caddyserver/caddy/caddyhttp/proxy"
+)
+
+func TestBlueGreen(t *testing.T) {
+	SetLogger(gittest.NewLogger(gittest.Open("file")))
+
+	blue, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	defer blue.Close()
+	green, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+
+	g := newLongCmd("./server", "--listen", "{upstream}", "--port={port}")
+	g.blueGreen = &blueGreen{
+		name:      "backend",
+		addresses: [2]string{blue.Addr().String(), green.Addr().String()},
+		active:    -1,
+	}
+
+	_, bluePort, _ := net.SplitHostPort(blue.Addr().String())
+	expected := "./server --listen " + blue.Addr().String() + " --port=" + bluePort
+	if command := g.instance(0).Command(); command != expected {
+		t.Errorf("Expected instance command %v found %v", expected, command)
+	}
+
+	// each pull switches to the other instance
+	for i, address := range []string{blue.Addr().String(), green.Addr().String(), blue.Addr().String()} {
+		if err := g.Exec("."); err != nil {
+			t.Fatalf("Test %v: Expected no error but found %v", i, err)
+		}
+		if Upstream("backend") != address {
+			t.Errorf("Test %v: Expected upstream %v found %v", i, address, Upstream("backend"))
+		}
+		if status := g.status(); status.Upstream != address {
+			t.Errorf("Test %v: Expected status upstream %v found %v", i, address, status.Upstream)
+		}
+	}
+
+	// the upstream is kept if the new instance is not ready
+	green.Close()
+	g.blueGreen.instances[1].ready.timeout = time.Millisecond * 300
+	if err := g.Exec("."); err == nil {
+		t.Errorf("Expected error for instance not ready")
+	}
+	if Upstream("backend") != blue.Addr().String() {
+		t.Errorf("Expected upstream %v found %v", blue.Addr(), Upstream("backend"))
+	}
+
+	g.haltProcess()
+	if Upstream("backend") != "" {
+		t.Errorf("Expected no upstream after halt found %v", Upstream("backend"))
+	}
+
+	// halting keeps the upstream published by a newer instance
+	g.blueGreen.active = 0
+	setUpstream("backend", green.Addr().String())
+	g.haltProcess()
+	if Upstream("backend") != green.Addr().String() {
+		t.Errorf("Expected upstream %v after halt found %v", green.Addr(), Upstream("backend"))
+	}
+	setUpstream("backend", "")
+}
+
+func TestUpstreamPolicy(t *testing.T) {
+	pool := proxy.HostPool{
+		&proxy.UpstreamHost{Name: "http://localhost:8081"},
+		&proxy.UpstreamHost{Name: "http://localhost:8082"},
+		&proxy.UpstreamHost{Name: "unix:/tmp/app.sock"},
+	}
+	policy := upstreamPolicy("policy")
+
+	for i, test := range []struct {
+		upstream string
+		expected *proxy.UpstreamHost
+	}{
+		{"", nil},
+		{"localhost:8081", pool[0]},
+		{"localhost:8082", pool[1]},
+		{"unix:/tmp/app.sock", pool[2]},
+		{"localhost:8083", nil},
+	} {
+		setUpstream("policy", test.upstream)
+		if host := policy.Select(pool, nil); host != test.expected {
+			t.Errorf("Test %v: Expected host %v found %v", i, test.expected, host)
+		}
+	}
+}
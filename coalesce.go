@@ -0,0 +1,137 @@
+package git
+
+import (
+	"strings"
+	"sync"
+)
+
+// Pull triggers.
+const (
+	TriggerManual   = "manual"
+	TriggerStartup  = "startup"
+	TriggerInterval = "interval"
+	TriggerWebhook  = "webhook"
+)
+
+// pullCall is a pull shared by coalesced callers.
+type pullCall struct {
+	triggers []string
+	started  []func() // called when the pull starts
+	done     chan struct{}
+	err      error
+}
+
+// start calls the started functions of the callers.
+func (p *pullCall) start() {
+	for _, fn := range p.started {
+		fn()
+	}
+}
+
+// add adds trigger to the triggers of the pull if not present.
+func (p *pullCall) add(trigger string) {
+	for _, t := range p.triggers {
+		if t == trigger {
+			return
+		}
+	}
+	p.triggers = append(p.triggers, trigger)
+}
+
+// pullFlight coalesces concurrent pulls of a repository.
+type pullFlight struct {
+	running bool
+	next    *pullCall // trailing pull of callers arriving during a pull
+	tag     string    // most recent tag pushed to a webhook
+	sync.Mutex
+}
+
+// setTag sets the most recent tag pushed to a webhook.
+func (f *pullFlight) setTag(tag string) {
+	f.Lock()
+	f.tag = tag
+	f.Unlock()
+}
+
+// takeTag returns and clears the most recent tag pushed to a webhook.
+func (f *pullFlight) takeTag() string {
+	f.Lock()
+	defer f.Unlock()
+	tag := f.tag
+	f.tag = ""
+	return tag
+}
+
+// PullFor attempts a git pull caused by trigger. Only one pull of the
+// repository runs at a time. Callers arriving while a pull runs are
+// coalesced into a single trailing pull with their combined triggers,
+// which is guaranteed to run and whose result they wait for.
+func (r *Repo) PullFor(trigger string) error {
+	return r.pullFor(trigger, nil)
+}
+
+// pullFor is PullFor calling started, if not nil, once the pull
+// actually starts, i.e. after waiting for a pull in progress and
+// for a worker of the Scheduler.
+func (r *Repo) pullFor(trigger string, started func()) error {
+	f := &r.flight
+	f.Lock()
+	if f.running {
+		if f.next == nil {
+			f.next = &pullCall{done: make(chan struct{})}
+		}
+		call := f.next
+		call.add(trigger)
+		if started != nil {
+			call.started = append(call.started, started)
+		}
+		f.Unlock()
+		<-call.done
+		return call.err
+	}
+	f.running = true
+	f.Unlock()
+
+	call := &pullCall{triggers: []string{trigger}}
+	if started != nil {
+		call.started = []func(){started}
+	}
+	err := r.schedule(call)
+	if call := r.nextPull(); call != nil {
+		go r.trailingPulls(call)
+	}
+	return err
+}
+
+// schedule executes call on the Scheduler.
+func (r *Repo) schedule(call *pullCall) error {
+	return Scheduler.run(r, call.triggers, func() error {
+		call.start()
+		return r.update(call.triggers)
+	})
+}
+
+// trailingPulls executes call and the pulls coalesced during it
+// until no more callers arrive.
+func (r *Repo) trailingPulls(call *pullCall) {
+	for ; call != nil; call = r.nextPull() {
+		Logger().Printf("Pulling %v again for %v.\n", r.URL, strings.Join(call.triggers, ", "))
+		call.err = r.schedule(call)
+		close(call.done)
+	}
+}
+
+// nextPull takes the trailing pull, if any. Otherwise, the repository
+// is marked as not pulling.
+func (r *Repo) nextPull() *pullCall {
+	f := &r.flight
+	f.Lock()
+	defer f.Unlock()
+
+	call := f.next
+	f.next = nil
+	if call == nil {
+		f.running = false
+	}
+	return call
+}
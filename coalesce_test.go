@@ -0,0 +1,62 @@
+package git
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/abiosoft/caddy-git/gittest"
+)
+
+func TestPullCoalesce(t *testing.T) {
+	SetLogger(gittest.NewLogger(gittest.Open("file")))
+
+	before := &toggleThen{}
+	repo := createRepo(&Repo{Before: []Then{before}})
+	check(t, repo.Prepare())
+
+	waitFlight := func(done func(f *pullFlight) bool) {
+		for {
+			repo.flight.Lock()
+			ok := done(&repo.flight)
+			repo.flight.Unlock()
+			if ok {
+				return
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+	}
+
+	// hold the pull in progress while callers arrive
+	repo.Lock()
+	errs := make(chan error, 3)
+	go func() { errs <- repo.Pull() }()
+	waitFlight(func(f *pullFlight) bool { return f.running })
+	go func() { errs <- repo.PullFor(TriggerWebhook) }()
+	go func() { errs <- repo.PullFor(TriggerInterval) }()
+	waitFlight(func(f *pullFlight) bool { return f.next != nil && len(f.next.triggers) == 2 })
+	repo.Unlock()
+
+	for i := 0; i < 3; i++ {
+		check(t, <-errs)
+	}
+	waitFlight(func(f *pullFlight) bool { return !f.running })
+
+	repo.Lock()
+	if before.runs != 2 {
+		t.Errorf("Expected %v pulls, found %v", 2, before.runs)
+	}
+	triggers := append([]string{}, repo.triggers...)
+	sort.Strings(triggers)
+	if len(triggers) != 2 || triggers[0] != TriggerInterval || triggers[1] != TriggerWebhook {
+		t.Errorf("Expected combined triggers, found %v", triggers)
+	}
+	repo.Unlock()
+
+	// pulls right after each other are not dropped
+	check(t, repo.PullFor(TriggerWebhook))
+	check(t, repo.PullFor(TriggerWebhook))
+	if before.runs != 4 {
+		t.Errorf("Expected %v pulls, found %v", 4, before.runs)
+	}
+}
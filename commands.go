@@ -2,12 +2,18 @@ package git
 
 import (
 	"bytes"
+	"fmt"
+	"io"
 	"os"
 	"strings"
 	"sync"
 	"time"
 )
 
+// defaultStopTimeout is the time to wait for a then_long command to stop
+// before killing it.
+const defaultStopTimeout = 10 * time.Second
+
 // Then is the command executed after successful pull.
 type Then interface {
 	Command() string
@@ -16,23 +22,52 @@ type Then interface {
 
 // NewThen creates a new Then command.
 func NewThen(command string, args ...string) Then {
-	return &gitCmd{command: command, args: args}
+	return newCmd(command, args...)
+}
+
+// newCmd creates a new gitCmd with default options.
+func newCmd(command string, args ...string) *gitCmd {
+	return &gitCmd{command: command, args: args, tailLines: defaultTailLines}
 }
 
 // NewLongThen creates a new long running Then comand.
 func NewLongThen(command string, args ...string) Then {
-	return &gitCmd{command: command, args: args, background: true, haltChan: make(chan struct{})}
+	return newLongCmd(command, args...)
+}
+
+// newLongCmd creates a new long running gitCmd with default options.
+func newLongCmd(command string, args ...string) *gitCmd {
+	return &gitCmd{
+		command:     command,
+		args:        args,
+		background:  true,
+		stopSignal:  defaultStopSignal,
+		stopTimeout: defaultStopTimeout,
+		restart:     defaultRestartPolicy,
+		tailLines:   defaultTailLines,
+	}
 }
 
 type gitCmd struct {
-	command    string
-	args       []string
-	dir        string
-	background bool
-	process    *os.Process
+	command     string
+	args        []string
+	dir         string
+	background  bool
+	process     *os.Process
+	stopSignal  os.Signal     // signal to stop the process with
+	stopTimeout time.Duration // time to wait before killing the process
+	restart     restartPolicy // policy to restart the process with
+	ready       *readyProbe   // probe to check if the process is ready
+	blueGreen   *blueGreen    // alternate instances for zero downtime restarts
+	log         *rotatingFile // log file for the output, os.Stderr if nil
+	tailLines   int           // number of output lines to keep in memory
+	tail        *tailBuffer   // last output lines of the most recent run
 
-	haltChan   chan struct{}
+	halt       chan struct{} // closed to stop the monitored process
+	done       chan struct{} // closed when the monitored process stops
 	monitoring bool
+	backoff    restartBackoff
+	crashLoop  bool // true if restarts exceeded the restart limit
 	sync.RWMutex
 }
 
@@ -47,128 +82,303 @@ func (g *gitCmd) Exec(dir string) error {
 	g.dir = dir
 	g.Unlock()
 
+	if g.blueGreen != nil {
+		return g.execBlueGreen(dir)
+	}
 	if g.background {
 		return g.execBackground(dir)
 	}
 	return g.exec(dir)
 }
 
-func (g *gitCmd) restart() error {
-	err := g.Exec(g.dir)
-	if err == nil {
-		Logger().Printf("Restart successful for '%v'.\n", g.Command())
-	} else {
-		Logger().Printf("Restart failed for '%v'.\n", g.Command())
-	}
-	return err
-}
-
 func (g *gitCmd) exec(dir string) error {
-	return runCmd(g.command, g.args, dir)
+	return runCmdTo(g.command, g.args, dir, g.output(nil))
 }
 
 func (g *gitCmd) execBackground(dir string) error {
-	// if existing process is running, kill it.
-	g.RLock()
-	if g.process != nil {
-		g.haltProcess()
+	// if existing process is running, stop it.
+	g.haltProcess()
+
+	logged := make(chan struct{})
+	process, err := runCmdBackground(g.command, g.args, dir, g.output(logged))
+	if err != nil {
+		return err
 	}
-	g.RUnlock()
+	g.Lock()
+	g.process = process
+	g.backoff = restartBackoff{}
+	g.crashLoop = false
+	g.Unlock()
+	stopped := g.monitorProcess()
 
-	process, err := runCmdBackground(g.command, g.args, dir)
-	if err == nil {
-		g.Lock()
-		g.process = process
-		g.Unlock()
-		g.monitorProcess()
+	return g.waitReady(logged, stopped)
+}
+
+// waitReady waits for the process to pass the readiness probe, if any.
+// logged is closed when the process logs the expected line and stopped
+// is closed when the process stops.
+func (g *gitCmd) waitReady(logged, stopped <-chan struct{}) error {
+	if g.ready == nil {
+		return nil
+	}
+	if err := g.ready.wait(logged, stopped); err != nil {
+		return fmt.Errorf("command '%v' not ready. Error: %v", g.Command(), err)
 	}
-	return err
+	Logger().Printf("Command '%v' is ready.\n", g.Command())
+	return nil
 }
 
-func (g *gitCmd) monitorProcess() {
-	g.RLock()
-	if g.process == nil {
-		g.RUnlock()
-		return
+// output returns the writer for the output of a run of the command.
+// The output is written to the log file, or os.Stderr if not set, and
+// its last lines are kept in memory. If the readiness probe waits for a
+// log line, logged is closed once the process logs it.
+func (g *gitCmd) output(logged chan struct{}) io.Writer {
+	tail := &tailBuffer{size: g.tailLines}
+	g.Lock()
+	g.tail = tail
+	g.Unlock()
+
+	var once sync.Once
+	lines := &lineWriter{fn: func(line string) {
+		tail.add(line)
+		if logged != nil && g.ready != nil && g.ready.kind == "log" && g.ready.pattern.MatchString(line) {
+			once.Do(func() { close(logged) })
+		}
+	}}
+
+	var out io.Writer = os.Stderr
+	if g.log != nil {
+		out = g.log
 	}
-	monitoring := g.monitoring
-	g.RUnlock()
+	return io.MultiWriter(out, lines)
+}
 
-	if monitoring {
-		return
+// outputTail returns the last lines of output of the most recent run.
+func (g *gitCmd) outputTail() []string {
+	g.RLock()
+	defer g.RUnlock()
+	if bg := g.blueGreen; bg != nil {
+		if bg.active < 0 || bg.instances[bg.active] == nil {
+			return nil
+		}
+		return bg.instances[bg.active].outputTail()
+	}
+	if g.tail == nil {
+		return nil
 	}
+	return g.tail.Lines()
+}
 
-	type resp struct {
-		state *os.ProcessState
-		err   error
+// monitorProcess starts monitoring the process, if not monitored yet.
+// It returns a channel that is closed when monitoring stops.
+func (g *gitCmd) monitorProcess() <-chan struct{} {
+	g.Lock()
+	if g.process == nil || g.monitoring {
+		done := g.done
+		g.Unlock()
+		return done
 	}
+	process := g.process
+	halt, done := make(chan struct{}), make(chan struct{})
+	g.halt, g.done = halt, done
+	g.monitoring = true
+	g.Unlock()
+
+	go g.monitor(process, halt, done)
+	return done
+}
+
+// monitor waits for process to terminate and restarts it according to
+// the restart policy. The process is stopped when halt is closed.
+func (g *gitCmd) monitor(process *os.Process, halt, done chan struct{}) {
+	defer g.clearProcess(done)
+
+	for {
+		started := time.Now()
+
+		var state *os.ProcessState
+		var err error
+		exited := make(chan struct{})
+		go func() {
+			state, err = process.Wait()
+			close(exited)
+		}()
 
-	respChan := make(chan resp)
+		select {
+		case <-halt:
+			g.stopProcess(process, exited)
+			return
+		case <-exited:
+		}
+
+		// processes the command started, e.g. node started by npm,
+		// may outlive it and hold resources a restart needs
+		if groupRunning(process) {
+			Logger().Printf("Command '%v' exited but left processes behind, stopping them...\n", g.Command())
+			g.stopProcess(process, exited)
+		}
+
+		failed := err != nil || !state.Success()
+		if failed {
+			Logger().Printf("Command '%v' terminated with error", g.Command())
+		} else {
+			Logger().Printf("Command '%v' exited.\n", g.Command())
+		}
+		if !g.restart.restarts(failed) {
+			return
+		}
 
-	go func() {
-		p, err := g.process.Wait()
-		respChan <- resp{p, err}
-	}()
+		if process = g.restartProcess(time.Since(started), halt); process == nil {
+			return
+		}
+	}
+}
 
-	go func() {
+// restartProcess starts the command again after the restart delay. It
+// returns nil if the restart limit is exceeded or halt is closed.
+func (g *gitCmd) restartProcess(uptime time.Duration, halt chan struct{}) *os.Process {
+	for {
 		g.Lock()
-		g.monitoring = true
+		delay, ok := g.backoff.next(g.restart, uptime, time.Now())
+		g.crashLoop = !ok
+		dir := g.dir
 		g.Unlock()
 
+		if !ok {
+			Logger().Printf("Command '%v' is crash looping, restarted %v times within %v. Giving up...\n", g.Command(), g.restart.limit, g.restart.window)
+			return nil
+		}
+
+		Logger().Printf("Restarting '%v' in %v.\n", g.Command(), delay)
 		select {
-		case <-g.haltChan:
-			g.killProcess()
-		case r := <-respChan:
-			if r.err != nil || !r.state.Success() {
-				Logger().Printf("Command '%v' terminated with error", g.Command())
-
-				g.Lock()
-				g.process = nil
-				g.monitoring = false
-				g.Unlock()
-
-				for i := 0; ; i++ {
-					if i >= numRetries {
-						Logger().Printf("Restart failed after 3 attempts for '%v'. Ignoring...\n", g.Command())
-						break
-					}
-					Logger().Printf("Attempting restart %v of %v for '%v'\n", i+1, numRetries, g.Command())
-					if g.restart() == nil {
-						break
-					}
-					time.Sleep(time.Second * 5)
-				}
-			} else {
-				g.Lock()
-				g.process = nil
-				g.monitoring = false
-				g.Unlock()
-			}
+		case <-halt:
+			return nil
+		case <-time.After(delay):
 		}
-	}()
 
+		process, err := runCmdBackground(g.command, g.args, dir, g.output(nil))
+		if err == nil && process != nil {
+			Logger().Printf("Restart successful for '%v'.\n", g.Command())
+			g.Lock()
+			g.process = process
+			g.Unlock()
+			return process
+		}
+		Logger().Printf("Restart failed for '%v'.\n", g.Command())
+		uptime = 0
+	}
 }
 
-func (g *gitCmd) killProcess() {
+// clearProcess resets the monitored process and closes done.
+func (g *gitCmd) clearProcess(done chan struct{}) {
 	g.Lock()
-	if err := g.process.Kill(); err != nil {
-		Logger().Printf("Could not terminate running command '%v'\n", g.command)
-	} else {
-		Logger().Printf("Command '%v' terminated from within.\n", g.command)
-	}
 	g.process = nil
 	g.monitoring = false
+	g.halt, g.done = nil, nil
 	g.Unlock()
+	close(done)
 }
 
-// haltProcess halts the running process
-func (g *gitCmd) haltProcess() {
+// stopProcess sends the stop signal to the process group of process.
+// If any process in the group is still running after the stop timeout,
+// the whole group is killed. exited is closed when process exits.
+func (g *gitCmd) stopProcess(process *os.Process, exited <-chan struct{}) {
+	if err := signalGroup(process, g.stopSignal); err != nil {
+		Logger().Printf("Could not signal running command '%v'. Error: %v\n", g.command, err)
+	}
+
+	timeout := time.After(g.stopTimeout)
+	for {
+		select {
+		case <-exited:
+			exited = nil
+		case <-timeout:
+			Logger().Printf("Command '%v' did not stop after %v, killing...\n", g.command, g.stopTimeout)
+			if err := signalGroup(process, os.Kill); err != nil {
+				Logger().Printf("Could not terminate running command '%v'\n", g.command)
+			}
+			if exited != nil {
+				<-exited
+			}
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+		if exited == nil && !groupRunning(process) {
+			Logger().Printf("Command '%v' terminated from within.\n", g.command)
+			return
+		}
+	}
+}
+
+// CommandStatus is the status of a then_long command.
+type CommandStatus struct {
+	Command   string // full command
+	Running   bool   // true if the process is running
+	Restarts  int    // number of restarts since the last pull
+	CrashLoop bool   // true if restarts exceeded the restart limit
+	Upstream  string // address of the active blue/green instance
+}
+
+// status returns the status of the command.
+func (g *gitCmd) status() CommandStatus {
 	g.RLock()
-	monitoring := g.monitoring
-	g.RUnlock()
+	defer g.RUnlock()
+	if bg := g.blueGreen; bg != nil {
+		if bg.active < 0 {
+			return CommandStatus{Command: g.Command()}
+		}
+		status := bg.instances[bg.active].status()
+		status.Command = g.Command()
+		status.Upstream = bg.addresses[bg.active]
+		return status
+	}
+	return CommandStatus{
+		Command:   g.Command(),
+		Running:   g.process != nil,
+		Restarts:  g.backoff.total,
+		CrashLoop: g.crashLoop,
+	}
+}
+
+// haltProcess halts the running process and waits for it to stop.
+func (g *gitCmd) haltProcess() {
+	if g.blueGreen != nil {
+		g.haltBlueGreen()
+		return
+	}
+
+	g.Lock()
+	if !g.monitoring {
+		g.Unlock()
+		return
+	}
+	halt, done := g.halt, g.done
+	select {
+	case <-halt:
+	default:
+		close(halt)
+	}
+	g.Unlock()
+
+	<-done
+}
+
+// haltBlueGreen halts both instances of a blue/green command.
+func (g *gitCmd) haltBlueGreen() {
+	g.Lock()
+	bg := g.blueGreen
+	instances := bg.instances
+	active := bg.active
+	bg.active = -1
+	g.Unlock()
 
-	if monitoring {
-		g.haltChan <- struct{}{}
+	if active >= 0 {
+		clearUpstream(bg.name, bg.addresses[active])
+	}
+	for _, instance := range instances {
+		if instance != nil {
+			instance.haltProcess()
+		}
 	}
 }
 
@@ -176,9 +386,14 @@ func (g *gitCmd) haltProcess() {
 // It runs command with args from directory at dir.
 // The executed process outputs to os.Stderr
 func runCmd(command string, args []string, dir string) error {
+	return runCmdTo(command, args, dir, os.Stderr)
+}
+
+// runCmdTo is like runCmd but the executed process outputs to out.
+func runCmdTo(command string, args []string, dir string, out io.Writer) error {
 	cmd := gos.Command(command, args...)
-	cmd.Stdout(os.Stderr)
-	cmd.Stderr(os.Stderr)
+	cmd.Stdout(out)
+	cmd.Stderr(out)
 	cmd.Dir(dir)
 	if err := cmd.Start(); err != nil {
 		return err
@@ -187,13 +402,15 @@ func runCmd(command string, args []string, dir string) error {
 }
 
 // runCmdBackground is a helper function to run commands in the background.
+// The command is started in its own process group and outputs to out.
 // It returns the resulting process and an error that occurs during while
 // starting the process (if any).
-func runCmdBackground(command string, args []string, dir string) (*os.Process, error) {
+func runCmdBackground(command string, args []string, dir string, out io.Writer) (*os.Process, error) {
 	cmd := gos.Command(command, args...)
 	cmd.Dir(dir)
-	cmd.Stdout(os.Stderr)
-	cmd.Stderr(os.Stderr)
+	cmd.Stdout(out)
+	cmd.Stderr(out)
+	cmd.SysProcAttr(processGroupAttr())
 	err := cmd.Start()
 	return cmd.Process(), err
 }
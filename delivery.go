@@ -0,0 +1,118 @@
+package git
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// deliveryTTL is how long delivery ids are remembered.
+	deliveryTTL = time.Hour * 24
+
+	// maxDeliveries is the maximum number of delivery ids remembered.
+	maxDeliveries = 10000
+
+	// maxDeliveryAge is the maximum age of a delivery with a timestamp,
+	// also allowed as clock skew for deliveries from the future.
+	maxDeliveryAge = time.Minute * 5
+)
+
+// deliveryHeaders are the headers providers identify deliveries with.
+var deliveryHeaders = []string{
+	"X-GitHub-Delivery",
+	"X-Gitlab-Event-UUID",
+	"X-Request-UUID",
+	"X-Gogs-Delivery",
+	"X-Gitea-Delivery",
+	"X-Forgejo-Delivery",
+	"X-Request-Id",
+}
+
+// deliveryTimestamps are the headers providers send the time of
+// a delivery with, in milliseconds since the epoch.
+var deliveryTimestamps = []string{
+	"X-Gitee-Timestamp",
+}
+
+// Deliveries holds the ids of recent webhook deliveries.
+var Deliveries = &deliveries{ids: make(map[string]time.Time)}
+
+// deliveries stores delivery ids with the time they were first seen.
+type deliveries struct {
+	ids map[string]time.Time
+	sync.Mutex
+}
+
+// deliveryID returns the delivery id in h or an empty string
+// if the provider does not send one.
+func deliveryID(h http.Header) string {
+	for _, header := range deliveryHeaders {
+		if id := h.Get(header); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// checkTimestamp checks that the delivery time in h, if any,
+// is within maxDeliveryAge of now.
+func checkTimestamp(h http.Header, now time.Time) error {
+	for _, header := range deliveryTimestamps {
+		value := h.Get(header)
+		if value == "" {
+			continue
+		}
+		ms, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid '%v' header", header)
+		}
+		age := now.Sub(time.Unix(0, ms*int64(time.Millisecond)))
+		if age > maxDeliveryAge || age < -maxDeliveryAge {
+			return fmt.Errorf("stale delivery, '%v' is %v off", header, age)
+		}
+	}
+	return nil
+}
+
+// add records the delivery id. It returns false if id was
+// seen within deliveryTTL.
+func (d *deliveries) add(id string, now time.Time) bool {
+	d.Lock()
+	defer d.Unlock()
+
+	if seen, ok := d.ids[id]; ok && now.Sub(seen) < deliveryTTL {
+		return false
+	}
+	if len(d.ids) >= maxDeliveries {
+		d.prune(now)
+	}
+	d.ids[id] = now
+	return true
+}
+
+// remove forgets the delivery id, e.g. if handling it failed
+// and the provider is expected to retry.
+func (d *deliveries) remove(id string) {
+	d.Lock()
+	defer d.Unlock()
+	delete(d.ids, id)
+}
+
+// prune removes expired ids. If none expired, the oldest id is
+// removed to keep the store bounded. d must be locked.
+func (d *deliveries) prune(now time.Time) {
+	var oldest string
+	for id, seen := range d.ids {
+		if now.Sub(seen) >= deliveryTTL {
+			delete(d.ids, id)
+		} else if oldest == "" || seen.Before(d.ids[oldest]) {
+			oldest = id
+		}
+	}
+	if len(d.ids) >= maxDeliveries {
+		delete(d.ids, oldest)
+	}
+}
@@ -0,0 +1,102 @@
+package git
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/abiosoft/caddy-git/gittest"
+)
+
+func TestDeliveries(t *testing.T) {
+	SetLogger(gittest.NewLogger(gittest.Open("file")))
+
+	// fixed ids need a store of their own
+	defer func(d *deliveries) { Deliveries = d }(Deliveries)
+	Deliveries = &deliveries{ids: make(map[string]time.Time)}
+
+	before := &toggleThen{}
+	repo := createRepo(&Repo{Before: []Then{before}})
+	repo.Hook = HookConfig{URL: "/delivery", Type: "generic"}
+	check(t, repo.Prepare())
+	hook := WebHook{Repos: []*Repo{repo}}
+
+	deliver := func(id, body string) int {
+		req, err := http.NewRequest("POST", "/delivery", bytes.NewBufferString(body))
+		check(t, err)
+		req.Header.Set("X-GitHub-Delivery", id)
+		code, _ := hook.ServeHTTP(httptest.NewRecorder(), req)
+		return code
+	}
+	push := `{"ref": "refs/heads/master"}`
+
+	for i, test := range []struct {
+		id   string
+		body string
+		code int
+		runs int
+	}{
+		{"1", push, 200, 1},
+		{"1", push, 200, 1},
+		{"2", push, 200, 2},
+		{"3", "invalid", 400, 2},
+		{"3", push, 200, 3},
+		{"3", push, 200, 3},
+	} {
+		if code := deliver(test.id, test.body); code != test.code {
+			t.Errorf("Test %v: Expected status %v, found %v", i, test.code, code)
+		}
+		if before.runs != test.runs {
+			t.Errorf("Test %v: Expected %v pulls, found %v", i, test.runs, before.runs)
+		}
+	}
+}
+
+func TestDeliveryTimestamp(t *testing.T) {
+	now := time.Now()
+	ms := func(t time.Time) string {
+		return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+	}
+	for i, test := range []struct {
+		timestamp string
+		shouldErr bool
+	}{
+		{"", false},
+		{ms(now), false},
+		{ms(now.Add(-time.Minute)), false},
+		{ms(now.Add(-time.Hour)), true},
+		{ms(now.Add(time.Hour)), true},
+		{"yesterday", true},
+	} {
+		h := http.Header{}
+		if test.timestamp != "" {
+			h.Set("X-Gitee-Timestamp", test.timestamp)
+		}
+		if err := checkTimestamp(h, now); (err != nil) != test.shouldErr {
+			t.Errorf("Test %v: Expected error %v, found %v", i, test.shouldErr, err)
+		}
+	}
+}
+
+func TestDeliveryStore(t *testing.T) {
+	d := &deliveries{ids: make(map[string]time.Time)}
+	now := time.Now()
+	if !d.add("a", now) || d.add("a", now.Add(time.Hour)) {
+		t.Errorf("Expected repeated delivery to be detected")
+	}
+	if !d.add("a", now.Add(deliveryTTL)) {
+		t.Errorf("Expected delivery to expire")
+	}
+	for i := 0; i < maxDeliveries+10; i++ {
+		d.add(strconv.Itoa(i), now.Add(time.Duration(i)))
+	}
+	if len(d.ids) > maxDeliveries {
+		t.Errorf("Expected at most %v deliveries, found %v", maxDeliveries, len(d.ids))
+	}
+	if !d.add("0", now) {
+		t.Errorf("Expected oldest delivery to be removed")
+	}
+}
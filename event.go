@@ -0,0 +1,251 @@
+package git
+
+import "strings"
+
+// Kinds of hook events.
+const (
+	EventPush    = "push"    // push to a branch
+	EventTag     = "tag"     // push of a tag
+	EventRelease = "release" // release of a tag
+)
+
+// zeroSHA is the commit providers report before created and
+// after deleted refs.
+const zeroSHA = "0000000000000000000000000000000000000000"
+
+// HookEvent is a webhook event independent of the provider.
+// Handlers parse payloads into events and hookDispatch decides
+// whether and how the repository is updated.
+type HookEvent struct {
+	Kind    string       // push, tag or release
+	Ref     string       // full ref e.g. refs/heads/feature/x
+	Before  string       // commit before the push, if known
+	After   string       // commit after the push, if known
+	Forced  bool         // the push rewrote history
+	Deleted bool         // the ref was deleted
+	Repo    HookRepo     // repository of the event
+	Pusher  string       // user who pushed
+	Commits []HookCommit // pushed commits, if known
+}
+
+// HookRepo identifies the repository of a hook event.
+type HookRepo struct {
+	Name string   // full name e.g. owner/repo
+	URLs []string // clone and web urls
+}
+
+// HookCommit is a commit of a hook event.
+type HookCommit struct {
+	ID      string
+	Message string
+	Author  string
+}
+
+// matches checks if the repository is the one at url. url may also be
+// a full name e.g. owner/repo, compared to the name and the paths of
+// the urls of the repository. The full name of a repository without
+// urls is compared to the path of url. Case, credentials and a .git
+// suffix are ignored.
+func (h HookRepo) matches(url string) bool {
+	id := repoIdentity(url)
+	if isRepoName(url) {
+		if h.Name != "" && repoIdentity(h.Name) == id {
+			return true
+		}
+		for _, u := range h.URLs {
+			if repoPath(repoIdentity(u)) == id {
+				return true
+			}
+		}
+		return false
+	}
+	for _, u := range h.URLs {
+		if repoIdentity(u) == id {
+			return true
+		}
+	}
+	return len(h.URLs) == 0 && h.Name != "" && repoPath(id) == repoIdentity(h.Name)
+}
+
+// isRepoName checks if s is a full name e.g. owner/repo rather than
+// a url with host.
+func isRepoName(s string) bool {
+	host := strings.SplitN(s, "/", 2)[0]
+	return !strings.Contains(s, "://") && !strings.ContainsAny(host, ".:@")
+}
+
+// repoPath returns the path of the repository identity id.
+func repoPath(id string) string {
+	if i := strings.Index(id, "/"); i >= 0 {
+		return id[i+1:]
+	}
+	return ""
+}
+
+// repoIdentity returns url as host/path without scheme, credentials,
+// port, case and .git suffix, for scp-like urls e.g.
+// git@github.com:user/repo.git too.
+func repoIdentity(url string) string {
+	id := strings.ToLower(url)
+	if i := strings.Index(id, "://"); i >= 0 {
+		id = id[i+3:]
+	}
+	host, path := id, ""
+	if i := strings.Index(id, "/"); i >= 0 {
+		host, path = id[:i], id[i:]
+	}
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+	if i := strings.Index(host, ":"); i >= 0 {
+		// port or path of scp-like url
+		if port := host[i+1:]; strings.Trim(port, "0123456789") == "" {
+			host = host[:i]
+		} else {
+			host, path = host[:i], "/"+port+path
+		}
+	}
+	id = strings.TrimSuffix(strings.TrimSuffix(host+path, "/"), ".git")
+	return strings.Trim(id, "/")
+}
+
+// newHookEvent returns an event for ref with the kind derived from it.
+// The kind is empty if ref is not a valid branch or tag ref.
+func newHookEvent(ref string) HookEvent {
+	e := HookEvent{Ref: ref}
+	switch {
+	case e.Branch() != "":
+		e.Kind = EventPush
+	case e.Tag() != "":
+		e.Kind = EventTag
+	}
+	return e
+}
+
+// Branch returns the branch name of the ref or an empty string
+// if the ref is not a branch. Branch names may contain slashes.
+func (e HookEvent) Branch() string {
+	if !strings.HasPrefix(e.Ref, "refs/heads/") {
+		return ""
+	}
+	return strings.TrimPrefix(e.Ref, "refs/heads/")
+}
+
+// Tag returns the tag name of the ref or an empty string
+// if the ref is not a tag.
+func (e HookEvent) Tag() string {
+	if !strings.HasPrefix(e.Ref, "refs/tags/") {
+		return ""
+	}
+	return strings.TrimPrefix(e.Ref, "refs/tags/")
+}
+
+// payloadRepo is the repository of payloads of GitHub and the
+// providers following its format.
+type payloadRepo struct {
+	FullName   string `json:"full_name"`
+	CloneURL   string `json:"clone_url"`
+	SSHURL     string `json:"ssh_url"`
+	GitURL     string `json:"git_url"`
+	GitHTTPURL string `json:"git_http_url"`
+	GitSSHURL  string `json:"git_ssh_url"`
+	HTMLURL    string `json:"html_url"`
+}
+
+// hookRepo returns the identity of the repository.
+func (p payloadRepo) hookRepo() HookRepo {
+	return HookRepo{
+		Name: p.FullName,
+		URLs: nonEmpty(p.CloneURL, p.SSHURL, p.GitURL, p.GitHTTPURL, p.GitSSHURL, p.HTMLURL),
+	}
+}
+
+// payloadUser is a user of payloads of GitHub and the providers
+// following its format.
+type payloadUser struct {
+	Name     string `json:"name"`
+	Login    string `json:"login"`
+	Username string `json:"username"`
+}
+
+// pushPayload is the push payload of GitHub and the providers
+// following its format, i.e. Gogs, Gitea and Gitee. Create events
+// of Gogs report the ref by name and type.
+type pushPayload struct {
+	Ref        string      `json:"ref"`
+	RefType    string      `json:"ref_type"`
+	Before     string      `json:"before"`
+	After      string      `json:"after"`
+	Forced     bool        `json:"forced"`
+	Deleted    bool        `json:"deleted"`
+	Repository payloadRepo `json:"repository"`
+	Pusher     payloadUser `json:"pusher"`
+	Sender     payloadUser `json:"sender"`
+	Commits    []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		Author  struct {
+			Name string `json:"name"`
+		} `json:"author"`
+	} `json:"commits"`
+}
+
+// event returns the hook event of the payload.
+func (p pushPayload) event() HookEvent {
+	ref := p.Ref
+	switch p.RefType {
+	case "tag":
+		ref = "refs/tags/" + ref
+	case "branch":
+		ref = "refs/heads/" + ref
+	}
+
+	e := newHookEvent(ref)
+	e.Before, e.After = p.Before, p.After
+	e.Forced = p.Forced
+	e.Deleted = p.Deleted || p.After == zeroSHA
+	e.Repo = p.Repository.hookRepo()
+	if pushers := nonEmpty(p.Pusher.Login, p.Pusher.Username, p.Pusher.Name, p.Sender.Login, p.Sender.Username); len(pushers) > 0 {
+		e.Pusher = pushers[0]
+	}
+	for _, c := range p.Commits {
+		e.Commits = append(e.Commits, HookCommit{ID: c.ID, Message: c.Message, Author: c.Author.Name})
+	}
+	return e
+}
+
+// releasePayload is the release payload of GitHub and the
+// providers following its format.
+type releasePayload struct {
+	Action  string `json:"action"`
+	Release struct {
+		TagName string `json:"tag_name"`
+	} `json:"release"`
+	Repository payloadRepo `json:"repository"`
+	Sender     payloadUser `json:"sender"`
+}
+
+// event returns the hook event of the payload. Deleted releases
+// are reported as deleted refs.
+func (p releasePayload) event() HookEvent {
+	e := newHookEvent("refs/tags/" + p.Release.TagName)
+	e.Kind = EventRelease
+	e.Deleted = p.Action == "deleted"
+	e.Repo = p.Repository.hookRepo()
+	e.Pusher = p.Sender.Login
+	if e.Pusher == "" {
+		e.Pusher = p.Sender.Username
+	}
+	return e
+}
+
+// nonEmpty returns values without empty strings.
+func nonEmpty(values ...string) []string {
+	var s []string
+	for _, v := range values {
+		if v != "" {
+			s = append(s, v)
+		}
+	}
+	return s
+}
@@ -0,0 +1,132 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// Results of a webhook delivery for a repository.
+const (
+	HookUpdated   = "updated"   // repository was pulled with new changes
+	HookUnchanged = "unchanged" // repository was pulled without changes
+	HookQueued    = "queued"    // a job pulls the repository in background
+	HookIgnored   = "ignored"   // delivery is about a different ref
+	HookSkipped   = "skipped"   // delivery is about a different repository
+	HookFailed    = "failed"    // delivery was rejected or the pull failed
+)
+
+// HookResult is the result of a webhook delivery for one of the
+// repositories sharing the hook url.
+type HookResult struct {
+	Repo   string `json:"repo"`
+	Result string `json:"result"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Job    string `json:"job,omitempty"`
+}
+
+// newHookResult returns the result of a webhook for repo handled with
+// status and err. changed reports if the pull, if any, changed the
+// commit of repo.
+func newHookResult(repo *Repo, status int, err error, changed bool) HookResult {
+	result := HookResult{Repo: repo.ID(), Status: status}
+	accepted, isAccepted := err.(hookAcceptedError)
+	switch {
+	case isAccepted:
+		result.Result, result.Status, result.Job = HookQueued, http.StatusAccepted, accepted.job.ID
+	case hookRejected(err):
+		result.Result, result.Status = HookSkipped, http.StatusForbidden
+	case hookIgnored(err):
+		result.Result = HookIgnored
+	case hookPullFailed(err):
+		result.Result, result.Status, result.Error = HookFailed, http.StatusInternalServerError, err.Error()
+	case hookFailed(status, err):
+		result.Result = HookFailed
+		if pullCancelled(err) {
+			result.Status = http.StatusPreconditionFailed
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+	case changed:
+		result.Result = HookUpdated
+	default:
+		result.Result = HookUnchanged
+	}
+	return result
+}
+
+// hookRecorder is a http.ResponseWriter discarding the response
+// of a handler, for webhooks handled for several repositories.
+type hookRecorder struct {
+	header http.Header
+	body   bytes.Buffer
+}
+
+// Header satisfies http.ResponseWriter.
+func (h *hookRecorder) Header() http.Header {
+	if h.header == nil {
+		h.header = make(http.Header)
+	}
+	return h.header
+}
+
+// Write satisfies http.ResponseWriter.
+func (h *hookRecorder) Write(b []byte) (int, error) {
+	return h.body.Write(b)
+}
+
+// WriteHeader satisfies http.ResponseWriter.
+func (h *hookRecorder) WriteHeader(int) {}
+
+// serveHooks handles the webhook request r for each of repos sharing
+// its url, at the same time. Each repo only updates for deliveries
+// about itself and its branch. The response lists the result for each
+// repo and fails only if the delivery failed for all of them.
+func serveHooks(w http.ResponseWriter, r *http.Request, repos []*Repo) (int, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return http.StatusRequestTimeout, errors.New("could not read body from request")
+	}
+
+	results := make([]HookResult, len(repos))
+	var wg sync.WaitGroup
+	for i, repo := range repos {
+		// each handler reads the body
+		req := new(http.Request)
+		*req = *r
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		handler := handlerFor(repo, req)
+		if handler == nil {
+			results[i] = HookResult{Repo: repo.ID(), Result: HookSkipped, Status: http.StatusBadRequest}
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, repo *Repo, handler hookHandler, req *http.Request) {
+			defer wg.Done()
+			before := repo.commit()
+			status, err := handleHook(&hookRecorder{}, req, repo, handler)
+			if err != nil {
+				Logger().Printf("Webhook for %v: %v\n", repo.ID(), err)
+			}
+			results[i] = newHookResult(repo, status, err, repo.commit() != before)
+		}(i, repo, handler, req)
+	}
+	wg.Wait()
+
+	status := results[0].Status
+	for _, result := range results {
+		if result.Result != HookFailed {
+			status = http.StatusOK
+			break
+		}
+	}
+	return writeJSON(w, status, struct {
+		Results []HookResult `json:"results"`
+	}{results})
+}
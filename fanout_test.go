@@ -0,0 +1,112 @@
+package git
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/abiosoft/caddy-git/gittest"
+)
+
+func TestHookFanout(t *testing.T) {
+	defer func() { gittest.CmdOutput = "success" }()
+	site := createRepo(&Repo{})
+	check(t, site.Prepare())
+	site.Name, site.URL, site.Hook = "site", "https://github.com/user/site", HookConfig{URL: "/hook"}
+	docs := &Repo{Name: "docs", URL: "https://github.com/user/docs", Branch: "master", Hook: HookConfig{URL: "/hook"}, stopped: true}
+	dev := &Repo{Name: "dev", URL: "https://github.com/user/site", Branch: "dev", Hook: HookConfig{URL: "/hook"}, stopped: true}
+	other := &Repo{Name: "other", URL: "https://github.com/user/site", Branch: "master", Hook: HookConfig{URL: "/other"}, stopped: true}
+	hook := WebHook{Repos: []*Repo{site, docs, dev, other}}
+
+	body := `{"ref": "refs/heads/master", "repository": {"full_name": "user/site", "clone_url": "https://github.com/user/site.git"}}`
+	push := func(expected map[string]string) []HookResult {
+		req, err := http.NewRequest("POST", "/hook", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("Could not create HTTP request: %v", err)
+		}
+		req.Header.Set("User-Agent", "GitHub-Hookshot/1")
+		req.Header.Set("X-Github-Event", "push")
+
+		rec := httptest.NewRecorder()
+		code, err := hook.ServeHTTP(rec, req)
+		if code != http.StatusOK || err != nil {
+			t.Fatalf("Expected status 200 but found %v %v", code, err)
+		}
+
+		var response struct {
+			Results []HookResult `json:"results"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Could not parse response %v: %v", rec.Body.String(), err)
+		}
+		if len(response.Results) != len(expected) {
+			t.Fatalf("Expected %v results but found %+v", len(expected), response.Results)
+		}
+		for _, result := range response.Results {
+			if result.Result != expected[result.Repo] {
+				t.Errorf("Expected %v to be %v but found %+v", result.Repo, expected[result.Repo], result)
+			}
+		}
+		return response.Results
+	}
+
+	// a new commit updates the repo, the same one leaves it unchanged
+	gittest.CmdOutput = "commit1"
+	push(map[string]string{"site": HookUpdated, "docs": HookSkipped, "dev": HookIgnored})
+	push(map[string]string{"site": HookUnchanged, "docs": HookSkipped, "dev": HookIgnored})
+
+	// the response does not wait for a pull in progress
+	site.Hook.Async = true
+	site.Lock()
+	unlock := time.AfterFunc(time.Second*5, site.Unlock)
+	results := push(map[string]string{"site": HookQueued, "docs": HookSkipped, "dev": HookIgnored})
+	if unlock.Stop() {
+		site.Unlock()
+	} else {
+		t.Error("Expected response before the pull in progress finished")
+	}
+	for _, result := range results {
+		for i := 0; result.Job != ""; i++ {
+			if job, _ := Jobs.Get(result.Job); job.Status == JobSucceeded || job.Status == JobFailed || i == 100 {
+				break
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+	}
+
+	// fails if it fails for all repos
+	req, _ := http.NewRequest("POST", "/hook", bytes.NewBufferString(`{"ref": ""}`))
+	req.Header.Set("User-Agent", "GitHub-Hookshot/1")
+	req.Header.Set("X-Github-Event", "push")
+	if code, _ := hook.ServeHTTP(httptest.NewRecorder(), req); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 but found %v", code)
+	}
+}
+
+func TestHookResult(t *testing.T) {
+	repo := &Repo{Name: "site"}
+	for i, test := range []struct {
+		status  int
+		err     error
+		changed bool
+		result  string
+		code    int
+	}{
+		{http.StatusOK, nil, true, HookUpdated, http.StatusOK},
+		{http.StatusOK, nil, false, HookUnchanged, http.StatusOK},
+		{http.StatusOK, hookIgnoredError{}, false, HookIgnored, http.StatusOK},
+		{http.StatusBadRequest, hookPullError{err: errors.New("pull failed")}, false, HookFailed, http.StatusInternalServerError},
+		{http.StatusBadRequest, pullCancelledError{}, false, HookFailed, http.StatusPreconditionFailed},
+		{http.StatusBadRequest, hookRejectedError{}, false, HookSkipped, http.StatusForbidden},
+		{http.StatusUnauthorized, errors.New("unauthorized"), false, HookFailed, http.StatusUnauthorized},
+	} {
+		result := newHookResult(repo, test.status, test.err, test.changed)
+		if result.Result != test.result || result.Status != test.code {
+			t.Errorf("Test %v: Expected %v %v but found %+v", i, test.result, test.code, result)
+		}
+	}
+}
@@ -1,18 +1,45 @@
 package git
 
 import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"hash"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
 // GenericHook is generic webhook.
 type GenericHook struct{}
 
-type gPush struct {
-	Ref string `json:"ref"`
+// GenericConfig is the configuration of the generic webhook.
+type GenericConfig struct {
+	RefPath      string // JSON path or ?query parameter of the ref
+	Auth         string // bearer or basic
+	User         string // user of basic auth
+	HMACHeader   string // header with the HMAC of the payload
+	HMACHash     string // sha1, sha256 or sha512
+	HMACEncoding string // hex or base64
+}
+
+// hmacHashes are the supported HMAC hash algorithms.
+var hmacHashes = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// hmacEncodings are the supported HMAC encodings.
+var hmacEncodings = map[string]func(string) ([]byte, error){
+	"hex":    hex.DecodeString,
+	"base64": base64.StdEncoding.DecodeString,
 }
 
 // DoesHandle satisfies hookHandler.
@@ -31,34 +58,152 @@ func (g GenericHook) Handle(w http.ResponseWriter, r *http.Request, repo *Repo)
 		return http.StatusRequestTimeout, errors.New("could not read body from request")
 	}
 
-	err = g.handlePush(body, repo)
+	err = g.handleAuth(r, body, repo.Hook)
 	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+
+	err = g.handlePush(r, body, repo)
+	if !hookIgnored(err) && err != nil {
 		return http.StatusBadRequest, err
 	}
 
-	return http.StatusOK, nil
+	return http.StatusOK, err
 }
 
-func (g GenericHook) handlePush(body []byte, repo *Repo) error {
-	var push gPush
+// handleAuth authenticates the request with the HMAC of the payload
+// and the bearer token or basic auth as configured.
+func (g GenericHook) handleAuth(r *http.Request, body []byte, hook HookConfig) error {
+	if hook.Generic.HMACHeader != "" {
+		if err := g.handleHMAC(r, body, hook); err != nil {
+			return err
+		}
+		if hook.Generic.Auth == "" {
+			return nil
+		}
+	}
+	if hook.Generic.Auth == "basic" {
+		return g.handleBasic(r, hook)
+	}
+	return g.handleToken(r, hook)
+}
 
-	err := json.Unmarshal(body, &push)
+// handleToken checks for a bearer token in the Authorization header
+// of the request. If one exists, verify that it matches the secret in
+// the Caddy configuration. The token is only required if hook requires
+// authentication.
+func (g GenericHook) handleToken(r *http.Request, hook HookConfig) error {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		if hook.RequireAuth {
+			return errors.New("the 'Authorization' header is required but was missing")
+		}
+		return nil
+	}
+	if hook.Secret == "" {
+		Logger().Println("unable to verify request. Secret not set in caddyfile")
+		return nil
+	}
+	return checkToken(token, hook.Secret)
+}
+
+// handleBasic checks for basic auth in the request. If it exists, verify
+// that it matches the user and the secret in the Caddy configuration.
+// It is only required if hook requires authentication.
+func (g GenericHook) handleBasic(r *http.Request, hook HookConfig) error {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		if hook.RequireAuth {
+			return errors.New("basic auth is required but was missing")
+		}
+		return nil
+	}
+	if hook.Secret == "" {
+		Logger().Println("unable to verify request. Secret not set in caddyfile")
+		return nil
+	}
+	userErr := checkToken(user, hook.Generic.User)
+	if err := checkToken(password, hook.Secret); err != nil || userErr != nil {
+		return errors.New("unable to verify request. The user or password do not match")
+	}
+	return nil
+}
+
+// handleHMAC checks for the HMAC of the payload in the configured header.
+// If it exists, verify it with the secret in the Caddy configuration.
+// It is only required if hook requires authentication.
+func (g GenericHook) handleHMAC(r *http.Request, body []byte, hook HookConfig) error {
+	config := hook.Generic
+	signature := r.Header.Get(config.HMACHeader)
+	if signature == "" {
+		if hook.RequireAuth {
+			return fmt.Errorf("the '%v' header is required but was missing", config.HMACHeader)
+		}
+		return nil
+	}
+	if hook.Secret == "" {
+		Logger().Println("unable to verify request. Secret not set in caddyfile")
+		return nil
+	}
+
+	hashName, encoding := config.HMACHash, config.HMACEncoding
+	if hashName == "" {
+		hashName = "sha256"
+	}
+	if encoding == "" {
+		encoding = "hex"
+	}
+	// signatures may be prefixed by the algorithm e.g. sha256=
+	signature = strings.TrimPrefix(signature, hashName+"=")
+	mac, err := hmacEncodings[encoding](signature)
+	if err != nil {
+		return errors.New("could not verify request signature. The signature is malformed")
+	}
+	return checkMAC(mac, hmacHashes[hashName], hook.Secret, body)
+}
+
+func (g GenericHook) handlePush(r *http.Request, body []byte, repo *Repo) error {
+	ref, err := g.ref(r, body, repo.Hook.Generic.RefPath)
 	if err != nil {
 		return err
 	}
 
-	// extract the branch being pushed from the ref string
-	// and if it matches with our locally tracked one, pull.
-	refSlice := strings.Split(push.Ref, "/")
-	if len(refSlice) != 3 {
-		return errors.New("the push request contained an invalid reference string")
+	// the ref may also be the plain branch name
+	if ref != "" && !strings.HasPrefix(ref, "refs/") {
+		ref = "refs/heads/" + ref
 	}
+	return hookDispatch(g, repo, newHookEvent(ref))
+}
 
-	branch := refSlice[2]
-	if branch == repo.Branch {
-		Logger().Print("Received pull notification for the tracking branch, updating...\n")
-		repo.Pull()
+// ref returns the ref of the request at path. path is either a query
+// parameter prefixed with ? or a dot separated JSON path into the
+// payload e.g. push.changes.0.ref; default is ref.
+func (g GenericHook) ref(r *http.Request, body []byte, path string) (string, error) {
+	if strings.HasPrefix(path, "?") {
+		return r.URL.Query().Get(path[1:]), nil
+	}
+	if path == "" {
+		path = "ref"
 	}
 
-	return nil
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return "", err
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value = v[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return "", fmt.Errorf("the push request has no %v", path)
+			}
+			value = v[i]
+		default:
+			return "", fmt.Errorf("the push request has no %v", path)
+		}
+	}
+	ref, _ := value.(string)
+	return ref, nil
 }
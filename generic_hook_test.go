@@ -2,6 +2,13 @@ package git
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -54,3 +61,49 @@ var pushGBodyOther = `
   "ref": "refs/heads/some-other-branch"
 }
 `
+
+func TestGenericConfig(t *testing.T) {
+	body := `{"push": {"changes": [{"ref": "refs/heads/master"}]}}`
+	sign := func(hash func() hash.Hash, encode func([]byte) string) string {
+		mac := hmac.New(hash, []byte("secret"))
+		mac.Write([]byte(body))
+		return encode(mac.Sum(nil))
+	}
+
+	for i, test := range []struct {
+		config  GenericConfig
+		url     string
+		headers map[string]string
+		code    int
+	}{
+		{GenericConfig{RefPath: "push.changes.0.ref"}, "/hook", map[string]string{"Authorization": "Bearer secret"}, 200},
+		{GenericConfig{RefPath: "push.changes.1.ref"}, "/hook", map[string]string{"Authorization": "Bearer secret"}, 400},
+		{GenericConfig{RefPath: "push.changes"}, "/hook", map[string]string{"Authorization": "Bearer secret"}, 400},
+		{GenericConfig{RefPath: "?branch"}, "/hook?branch=other", map[string]string{"Authorization": "Bearer secret"}, 200},
+		{GenericConfig{RefPath: "?branch"}, "/hook", map[string]string{"Authorization": "Bearer secret"}, 400},
+		{GenericConfig{RefPath: "push.changes.0.ref"}, "/hook", nil, 401},
+		{GenericConfig{RefPath: "push.changes.0.ref", Auth: "basic", User: "ci"}, "/hook", map[string]string{"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte("ci:secret"))}, 200},
+		{GenericConfig{RefPath: "push.changes.0.ref", Auth: "basic", User: "ci"}, "/hook", map[string]string{"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte("other:secret"))}, 401},
+		{GenericConfig{RefPath: "push.changes.0.ref", Auth: "basic", User: "ci"}, "/hook", map[string]string{"Authorization": "Bearer secret"}, 401},
+		{GenericConfig{RefPath: "push.changes.0.ref", HMACHeader: "X-Signature"}, "/hook", map[string]string{"X-Signature": sign(sha256.New, hex.EncodeToString)}, 200},
+		{GenericConfig{RefPath: "push.changes.0.ref", HMACHeader: "X-Signature"}, "/hook", map[string]string{"X-Signature": "sha256=" + sign(sha256.New, hex.EncodeToString)}, 200},
+		{GenericConfig{RefPath: "push.changes.0.ref", HMACHeader: "X-Signature"}, "/hook", map[string]string{"X-Signature": sign(sha1.New, hex.EncodeToString)}, 401},
+		{GenericConfig{RefPath: "push.changes.0.ref", HMACHeader: "X-Signature"}, "/hook", map[string]string{"Authorization": "Bearer secret"}, 401},
+		{GenericConfig{RefPath: "push.changes.0.ref", HMACHeader: "X-Signature", HMACHash: "sha512", HMACEncoding: "base64"}, "/hook", map[string]string{"X-Signature": sign(sha512.New, base64.StdEncoding.EncodeToString)}, 200},
+		{GenericConfig{RefPath: "push.changes.0.ref", HMACHeader: "X-Signature", HMACHash: "sha512", HMACEncoding: "base64"}, "/hook", map[string]string{"X-Signature": "%%%"}, 401},
+	} {
+		repo := &Repo{Branch: "other", Hook: HookConfig{URL: "/hook", Secret: "secret", RequireAuth: true, Generic: test.config}}
+		req, err := http.NewRequest("POST", test.url, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("Test %v: Could not create HTTP request: %v", i, err)
+		}
+		for k, v := range test.headers {
+			req.Header.Set(k, v)
+		}
+
+		code, err := GenericHook{}.Handle(httptest.NewRecorder(), req, repo)
+		if code != test.code {
+			t.Errorf("Test %d: Expected response code to be %d but was %d: %v", i, test.code, code, err)
+		}
+	}
+}
@@ -1,11 +1,14 @@
 package git
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
 	"net/url"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/abiosoft/caddy-git/gitos"
@@ -59,6 +62,7 @@ func (r RepoURL) Val() string {
 // Repo is the structure that holds required information
 // of a git repository.
 type Repo struct {
+	Name       string        // Name to identify the repository
 	URL        RepoURL       // Repository URL
 	Path       string        // Directory to pull to
 	Host       string        // Git domain host e.g. github.com
@@ -67,30 +71,118 @@ type Repo struct {
 	Interval   time.Duration // Interval between pulls
 	CloneArgs  []string      // Additonal cli args to pass to git clone
 	PullArgs   []string      // Additonal cli args to pass to git pull
+	Before     []Then        // Commands to execute before git pull
 	Then       []Then        // Commands to execute after successful git pull
+	Rollback   bool          // Rollback to previous commit if deploy fails
+	Health     HealthCheck   // Health check to execute after Then
 	pulled     bool          // true if there was a successful pull
 	lastPull   time.Time     // time of the last successful pull
 	lastCommit string        // hash for the most recent commit
+	commitLock sync.RWMutex  // guards lastCommit for reads without the lock
 	latestTag  string        // latest tag name
+	failed     string        // hash of the most recent rolled back commit
+	lastDeploy deployResult  // result of the most recent deploy
+	stopped    bool          // true if the repository is shut down
+	paused     int32         // 1 if pulling is paused, accessed atomically
+	id         string        // id derived when configured
+	flight     pullFlight    // coalesces concurrent pulls
+	triggers   []string      // triggers of the pull in progress
 	Hook       HookConfig    // Webhook configuration
 	sync.Mutex
 }
 
+// ID returns the stable identifier of the repository. It is Name, if set,
+// or derived from the URL, path and branch as configured.
+func (r *Repo) ID() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	if r.id != "" {
+		return r.id
+	}
+	return repoID(r.URL, r.Path, r.Branch)
+}
+
+// repoID derives a repository id from url, path and branch.
+// Credentials in url are ignored.
+func repoID(url RepoURL, path, branch string) string {
+	hash := sha1.Sum([]byte(url.String() + "\x00" + path + "\x00" + branch))
+	return hex.EncodeToString(hash[:6])
+}
+
+// setPaused pauses or resumes pulling the repository.
+func (r *Repo) setPaused(paused bool) {
+	var v int32
+	if paused {
+		v = 1
+	}
+	atomic.StoreInt32(&r.paused, v)
+}
+
+// isPaused checks if pulling the repository is paused.
+func (r *Repo) isPaused() bool {
+	return atomic.LoadInt32(&r.paused) == 1
+}
+
+// RepoStatus is the status of a repository.
+type RepoStatus struct {
+	ID         string          // Repository id
+	Paused     bool            // true if pulling is paused
+	URL        string          // Repository URL without credentials
+	Path       string          // Directory pulled to
+	Commands   []CommandStatus // Status of then_long commands
+	LastDeploy *DeployResult   // Result of the most recent deploy
+}
+
+// Status returns the status of the repository.
+func (r *Repo) Status() RepoStatus {
+	status := RepoStatus{ID: r.ID(), Paused: r.isPaused(), URL: r.URL.String(), Path: r.Path}
+
+	r.lastDeploy.RLock()
+	status.LastDeploy = r.lastDeploy.result
+	r.lastDeploy.RUnlock()
+
+	for _, then := range r.Then {
+		if g, ok := then.(*gitCmd); ok && g.background {
+			status.Commands = append(status.Commands, g.status())
+		}
+	}
+	return status
+}
+
 // Pull attempts a git pull.
-// It retries at most numRetries times if error occurs
+// It retries at most numRetries times if error occurs.
+// Concurrent calls are coalesced, see PullFor.
 func (r *Repo) Pull() error {
+	return r.PullFor(TriggerManual)
+}
+
+// update attempts a git pull caused by triggers and deploys new changes.
+// It retries at most numRetries times if error occurs.
+func (r *Repo) update(triggers []string) error {
 	r.Lock()
 	defer r.Unlock()
 
-	// prevent a pull if the last one was less than 5 seconds ago
-	if gos.TimeSince(r.lastPull) < 5*time.Second {
+	// no more pulls after shutdown
+	if r.stopped {
+		return nil
+	}
+	if r.isPaused() {
+		Logger().Printf("Pulling %v is paused.\n", r.ID())
 		return nil
 	}
+	r.triggers = triggers
 
 	// keep last commit hash for comparison later
 	lastCommit := r.lastCommit
 
-	var err error
+	// any failing before command cancels the pull
+	err := r.execBefore()
+	if err != nil {
+		Logger().Println(err)
+		return err
+	}
+
 	// Attempt to pull at most numRetries times
 	for i := 0; i < numRetries; i++ {
 		if err = r.pull(); err == nil {
@@ -109,7 +201,108 @@ func (r *Repo) Pull() error {
 		Logger().Println("No new changes.")
 		return nil
 	}
-	return r.execThen()
+
+	// a rolled back commit is not retried until a newer one arrives
+	if r.failed != "" && r.lastCommit == r.failed {
+		Logger().Printf("Commit %v failed to deploy before, keeping %v.\n", r.failed, lastCommit)
+		if err = r.checkoutCommit(lastCommit); err == nil {
+			r.setLastCommit(lastCommit)
+		}
+		return err
+	}
+
+	if err = r.deploy(); err != nil && r.Rollback && lastCommit != "" {
+		return r.rollback(lastCommit, err)
+	}
+	return err
+}
+
+// deploy executes r.Then followed by the health check, if any.
+func (r *Repo) deploy() error {
+	if err := r.execThen(); err != nil {
+		return err
+	}
+	if r.Health.URL == "" {
+		return nil
+	}
+	return r.Health.Check()
+}
+
+// rollback checks out commit after a failed deploy and executes the
+// deploy again for it. The failed commit is kept to prevent deploying
+// it again. cause is the error that failed the deploy.
+func (r *Repo) rollback(commit string, cause error) error {
+	r.failed = r.lastCommit
+	Logger().Printf("Deploy of commit %v failed, rolling back to %v.\n", r.failed, commit)
+
+	if err := r.checkoutCommit(commit); err != nil {
+		return mergeErrors(cause, err)
+	}
+	r.setLastCommit(commit)
+	return mergeErrors(cause, r.deploy())
+}
+
+// shutdown stops the service pulling the repository, waits for a pull
+// in progress to finish and stops the then_long commands. The repository
+// is not pulled anymore until resumed. Only the first call after start
+// or resume stops the commands.
+func (r *Repo) shutdown() error {
+	Services.stopRepo(r)
+	Services.unregister(r)
+
+	r.Lock()
+	defer r.Unlock()
+	// shutdown runs again after a successful reload, when the new
+	// instance already shares the upstreams and log files
+	if r.stopped {
+		return nil
+	}
+	r.stopped = true
+	for _, then := range r.Then {
+		g, ok := then.(*gitCmd)
+		if !ok {
+			continue
+		}
+		if g.background {
+			g.haltProcess()
+		}
+		// the new instance opens the log file again
+		if g.log != nil {
+			if err := g.log.Close(); err != nil {
+				Logger().Printf("Could not close log file %v. Error: %v\n", g.log.path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// resume restarts a repository after shutdown. The service pulling the
+// repository is started if periodic is true and the then_long commands
+// are started again if the repository was pulled before.
+func (r *Repo) resume(periodic bool) error {
+	r.Lock()
+	defer r.Unlock()
+	if !r.stopped {
+		return nil
+	}
+	r.stopped = false
+	if err := Services.register(r); err != nil {
+		return err
+	}
+	if periodic {
+		Start(r)
+	}
+	if r.lastCommit == "" {
+		return nil
+	}
+
+	var errs error
+	for _, then := range r.Then {
+		if g, ok := then.(*gitCmd); ok && g.background {
+			errs = mergeErrors(errs, g.Exec(r.Path))
+		}
+	}
+	return errs
 }
 
 // pull performs git pull, or git clone if repository does not exist.
@@ -121,7 +314,7 @@ func (r *Repo) pull() error {
 	}
 
 	// if latest tag config is set
-	if r.Branch == latestTag {
+	if r.tagMode() {
 		return r.checkoutLatestTag()
 	}
 
@@ -131,7 +324,9 @@ func (r *Repo) pull() error {
 		r.pulled = true
 		r.lastPull = time.Now()
 		Logger().Printf("%v pulled.\n", r.URL)
-		r.lastCommit, err = r.mostRecentCommit()
+		var commit string
+		commit, err = r.mostRecentCommit()
+		r.setLastCommit(commit)
 	}
 	return err
 }
@@ -140,7 +335,7 @@ func (r *Repo) pull() error {
 func (r *Repo) clone() error {
 	params := append([]string{"clone", "-b", r.Branch}, append(r.CloneArgs, r.URL.Val(), r.Path)...)
 
-	tagMode := r.Branch == latestTag
+	tagMode := r.tagMode()
 	if tagMode {
 		params = append([]string{"clone"}, append(r.CloneArgs, r.URL.Val(), r.Path)...)
 	}
@@ -150,7 +345,9 @@ func (r *Repo) clone() error {
 		r.pulled = true
 		r.lastPull = time.Now()
 		Logger().Printf("%v pulled.\n", r.URL)
-		r.lastCommit, err = r.mostRecentCommit()
+		var commit string
+		commit, err = r.mostRecentCommit()
+		r.setLastCommit(commit)
 
 		// if latest tag config is set.
 		if tagMode {
@@ -161,7 +358,28 @@ func (r *Repo) clone() error {
 	return err
 }
 
+// tagMode checks if the repository is pulled by tags rather than
+// a branch, i.e. the branch is {latest} or a version constraint.
+func (r *Repo) tagMode() bool {
+	return r.Branch == latestTag || isSemverConstraint(r.Branch)
+}
+
+// matchesTag checks if a pushed tag is to be checked out in tag mode.
+func (r *Repo) matchesTag(tag string) bool {
+	if r.Branch == latestTag {
+		return true
+	}
+	constraint, err := parseSemverConstraint(r.Branch)
+	if err != nil {
+		return false
+	}
+	v, _, ok := parseSemver(tag)
+	return ok && constraint.match(v)
+}
+
 // checkoutLatestTag checks out the latest tag of the repository.
+// A tag pushed to a webhook is checked out for {latest} while the
+// highest tag satisfying the version constraint is checked out otherwise.
 func (r *Repo) checkoutLatestTag() error {
 	tag, err := r.fetchLatestTag()
 	if err != nil {
@@ -179,7 +397,9 @@ func (r *Repo) checkoutLatestTag() error {
 	params := []string{"checkout", "tags/" + tag}
 	if err = r.gitCmd(params, r.Path); err == nil {
 		r.latestTag = tag
-		r.lastCommit, err = r.mostRecentCommit()
+		var commit string
+		commit, err = r.mostRecentCommit()
+		r.setLastCommit(commit)
 		Logger().Printf("Tag %v checkout done.\n", tag)
 	}
 	return err
@@ -270,6 +490,21 @@ func (r *Repo) Prepare() error {
 	return fmt.Errorf("cannot git clone into %v, directory not empty", r.Path)
 }
 
+// setLastCommit sets the most recent commit. r must be locked.
+func (r *Repo) setLastCommit(commit string) {
+	r.commitLock.Lock()
+	r.lastCommit = commit
+	r.commitLock.Unlock()
+}
+
+// commit returns the most recent commit without waiting for
+// a pull in progress.
+func (r *Repo) commit() string {
+	r.commitLock.RLock()
+	defer r.commitLock.RUnlock()
+	return r.lastCommit
+}
+
 // getMostRecentCommit gets the hash of the most recent commit to the
 // repository. Useful for checking if changes occur.
 func (r *Repo) mostRecentCommit() (string, error) {
@@ -289,6 +524,24 @@ func (r *Repo) fetchLatestTag() (string, error) {
 	if err != nil {
 		return "", err
 	}
+	// a pushed tag is the latest
+	if pushed := r.flight.takeTag(); pushed != "" && r.Branch == latestTag {
+		return pushed, nil
+	}
+
+	// highest tag satisfying the constraint
+	if isSemverConstraint(r.Branch) {
+		constraint, err := parseSemverConstraint(r.Branch)
+		if err != nil {
+			return "", err
+		}
+		tags, err := runCmdOutput(gitBinary, []string{"tag", "--list"}, r.Path)
+		if err != nil {
+			return "", err
+		}
+		return constraint.latest(strings.Fields(tags)), nil
+	}
+
 	// retrieve latest tag
 	command := gitBinary + ` describe origin --abbrev=0 --tags`
 	c, args, err := caddy.SplitCommandAndArgs(command)
@@ -308,20 +561,89 @@ func (r *Repo) originURL() (string, error) {
 	return runCmdOutput(gitBinary, args, r.Path)
 }
 
+// execBefore executes r.Before.
+// It is triggered before git pull and stops at the first failing
+// command, returning a pullCancelledError.
+func (r *Repo) execBefore() error {
+	for _, command := range r.Before {
+		if err := command.Exec(r.Path); err != nil {
+			return pullCancelledError{command: command.Command(), err: err}
+		}
+		Logger().Printf("Command '%v' successful.\n", command.Command())
+	}
+	return nil
+}
+
 // execThen executes r.Then.
 // It is trigged after successful git pull
 func (r *Repo) execThen() error {
+	result := &DeployResult{Commit: r.lastCommit, Time: time.Now(), Triggers: r.triggers}
 	var errs error
 	for _, command := range r.Then {
 		err := command.Exec(r.Path)
+
+		output := CommandOutput{Command: command.Command()}
+		if g, ok := command.(*gitCmd); ok {
+			output.Lines = g.outputTail()
+		}
+		result.Outputs = append(result.Outputs, output)
+
 		if err == nil {
 			Logger().Printf("Command '%v' successful.\n", command.Command())
+		} else {
+			err = commandError{command: command.Command(), err: err, output: output.Lines}
 		}
 		errs = mergeErrors(errs, err)
 	}
+	if errs != nil {
+		result.Error = errs.Error()
+	}
+
+	r.lastDeploy.Lock()
+	r.lastDeploy.result = result
+	r.lastDeploy.Unlock()
 	return errs
 }
 
+// DeployResult is the result of the commands executed after a pull.
+type DeployResult struct {
+	Commit   string          `json:"commit"`          // Commit deployed
+	Time     time.Time       `json:"time"`            // Time of the deploy
+	Triggers []string        `json:"triggers"`        // Triggers of the pull, e.g. webhook
+	Error    string          `json:"error,omitempty"` // Error of failed commands, if any
+	Outputs  []CommandOutput `json:"outputs"`         // Output of the commands
+}
+
+// CommandOutput is the last lines of output of a command.
+type CommandOutput struct {
+	Command string   `json:"command"`
+	Lines   []string `json:"lines"`
+}
+
+// deployResult guards the result of the most recent deploy.
+type deployResult struct {
+	result *DeployResult
+	sync.RWMutex
+}
+
+// pullCancelledError is returned when a before command
+// cancels a pull.
+type pullCancelledError struct {
+	command string
+	err     error
+}
+
+// Error satisfies error interface
+func (p pullCancelledError) Error() string {
+	return fmt.Sprintf("pull cancelled by command '%v'. Error: %v", p.command, p.err)
+}
+
+// pullCancelled checks if err is of type pullCancelledError.
+func pullCancelled(err error) bool {
+	_, ok := err.(pullCancelledError)
+	return ok
+}
+
 func mergeErrors(errs ...error) error {
 	if len(errs) == 0 {
 		return nil
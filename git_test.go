@@ -1,6 +1,7 @@
 package git
 
 import (
+	"fmt"
 	"io/ioutil"
 	"log"
 	"testing"
@@ -134,40 +135,111 @@ Command 'echo Hello' successful.
 			t.Errorf("Pull with Error %v: Expected %v found %v", i, expected, err.Error())
 		}
 	}
+}
 
-	// timeout checks
-	timeoutTests := []struct {
-		repo       *Repo
-		shouldPull bool
-	}{
-		{&Repo{Interval: time.Millisecond * 4900}, false},
-		{&Repo{Interval: time.Millisecond * 1}, false},
-		{&Repo{Interval: time.Second * 5}, true},
-		{&Repo{Interval: time.Second * 10}, true},
+func TestBefore(t *testing.T) {
+	logFile := gittest.Open("file")
+	SetLogger(log.New(logFile, "", 0))
+
+	repo := createRepo(&Repo{
+		Before: []Then{NewThen("echo", "Hello")},
+	})
+	check(t, repo.Prepare())
+	check(t, repo.Pull())
+	if repo.lastPull.IsZero() {
+		t.Errorf("Expected pull after successful before command")
 	}
 
-	for i, r := range timeoutTests {
-		r.repo = createRepo(r.repo)
+	repo = createRepo(&Repo{
+		Before: []Then{NewThen("echo", "Hello"), failingThen{"lock"}},
+		Then:   []Then{NewThen("echo", "Deploy")},
+	})
+	check(t, repo.Prepare())
+	err := repo.Pull()
+	if !pullCancelled(err) {
+		t.Errorf("Expected cancelled pull but found %v", err)
+	}
+	if !repo.lastPull.IsZero() {
+		t.Errorf("Expected no pull after failing before command")
+	}
+}
 
-		err := r.repo.Prepare()
-		check(t, err)
-		err = r.repo.Pull()
-		check(t, err)
+func TestRollback(t *testing.T) {
+	SetLogger(log.New(gittest.Open("file"), "", 0))
+	defer func() { gittest.CmdOutput = "success" }()
 
-		before := r.repo.lastPull
+	deploy := &toggleThen{}
+	repo := createRepo(&Repo{Then: []Then{deploy}})
+	repo.Rollback = true
+	check(t, repo.Prepare())
 
-		gittest.Sleep(r.repo.Interval)
+	gittest.CmdOutput = "commit1"
+	check(t, repo.Pull())
 
-		err = r.repo.Pull()
-		after := r.repo.lastPull
-		check(t, err)
+	// failed deploy rolls back to the previous commit
+	gittest.CmdOutput = "commit2"
+	deploy.failures = 1
+	gittest.Sleep(time.Second * 5)
+	if err := repo.Pull(); err == nil {
+		t.Errorf("Expected failed deploy but found nil")
+	}
+	if repo.lastCommit != "commit1" || repo.failed != "commit2" {
+		t.Errorf("Expected rollback from commit2 to commit1 but found %v %v", repo.failed, repo.lastCommit)
+	}
+	if deploy.runs != 3 {
+		t.Errorf("Expected deploy to run %v times but found %v", 3, deploy.runs)
+	}
+	if result := repo.Status().LastDeploy; result == nil || result.Commit != "commit1" || result.Error != "" {
+		t.Errorf("Expected successful deploy of commit1 but found %+v", result)
+	}
 
-		expected := after.After(before)
-		if expected != r.shouldPull {
-			t.Errorf("Pull with Error %v: Expected %v found %v", i, expected, r.shouldPull)
-		}
+	// failed commit is not retried
+	gittest.Sleep(time.Second * 5)
+	check(t, repo.Pull())
+	if repo.lastCommit != "commit1" || deploy.runs != 3 {
+		t.Errorf("Expected commit1 to be kept but found %v", repo.lastCommit)
 	}
 
+	// newer commit is deployed
+	gittest.CmdOutput = "commit3"
+	gittest.Sleep(time.Second * 5)
+	check(t, repo.Pull())
+	if repo.lastCommit != "commit3" || deploy.runs != 4 {
+		t.Errorf("Expected commit3 to be deployed but found %v", repo.lastCommit)
+	}
+}
+
+// toggleThen is a Then that fails the next number of failures runs
+// and counts its runs.
+type toggleThen struct {
+	failures int
+	runs     int
+}
+
+func (f *toggleThen) Command() string {
+	return "deploy"
+}
+
+func (f *toggleThen) Exec(dir string) error {
+	f.runs++
+	if f.failures > 0 {
+		f.failures--
+		return fmt.Errorf("deploy failed")
+	}
+	return nil
+}
+
+// failingThen is a Then that always fails.
+type failingThen struct {
+	command string
+}
+
+func (f failingThen) Command() string {
+	return f.command
+}
+
+func (f failingThen) Exec(dir string) error {
+	return fmt.Errorf("%v failed", f.command)
 }
 
 func createRepo(r *Repo) *Repo {
@@ -196,6 +268,9 @@ func createRepo(r *Repo) *Repo {
 	if r.Path != "" {
 		repo.Path = r.Path
 	}
+	if r.Before != nil {
+		repo.Before = r.Before
+	}
 	if r.Then != nil {
 		repo.Then = r.Then
 	}
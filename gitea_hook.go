@@ -0,0 +1,118 @@
+package git
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+)
+
+// GiteaHook is the webhook for Gitea and its fork Forgejo.
+type GiteaHook struct{}
+
+// DoesHandle satisfies hookHandler.
+func (g GiteaHook) DoesHandle(h http.Header) bool {
+	// Gitea also sends X-Gogs-Event, so it must be detected before Gogs
+	return g.event(h) != ""
+}
+
+// event returns the event of the request, sent by Forgejo
+// as X-Forgejo-Event and by Gitea as X-Gitea-Event.
+func (g GiteaHook) event(h http.Header) string {
+	if event := h.Get("X-Forgejo-Event"); event != "" {
+		return event
+	}
+	return h.Get("X-Gitea-Event")
+}
+
+// Handle satisfies hookHandler.
+func (g GiteaHook) Handle(w http.ResponseWriter, r *http.Request, repo *Repo) (int, error) {
+	if r.Method != "POST" {
+		return http.StatusMethodNotAllowed, errors.New("the request had an invalid method")
+	}
+
+	// read full body - required for signature
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return http.StatusRequestTimeout, errors.New("could not read body from request")
+	}
+
+	err = g.handleSignature(r, body, repo.Hook)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+
+	event := g.event(r.Header)
+	if event == "" {
+		return http.StatusBadRequest, errors.New("the 'X-Gitea-Event' header is required but was missing")
+	}
+
+	switch event {
+	case "push", "create":
+		err = g.handlePush(body, repo)
+		if !hookIgnored(err) && err != nil {
+			return http.StatusBadRequest, err
+		}
+	case "release":
+		err = g.handleRelease(body, repo)
+		if !hookIgnored(err) && err != nil {
+			return http.StatusBadRequest, err
+		}
+
+	// return 400 if we do not handle the event type.
+	// This is to visually show the user a configuration error in the Gitea ui.
+	default:
+		return http.StatusBadRequest, nil
+	}
+
+	return http.StatusOK, err
+}
+
+// handleSignature checks for the HMAC-SHA256 signature of the payload
+// in the request. If it exists, verify that it matches the secret in
+// the Caddy configuration. It is only required if hook requires
+// authentication.
+func (g GiteaHook) handleSignature(r *http.Request, body []byte, hook HookConfig) error {
+	signature := r.Header.Get("X-Forgejo-Signature")
+	if signature == "" {
+		signature = r.Header.Get("X-Gitea-Signature")
+	}
+	if signature == "" {
+		if hook.RequireAuth {
+			return errors.New("the 'X-Gitea-Signature' header is required but was missing")
+		}
+		return nil
+	}
+	if hook.Secret == "" {
+		Logger().Println("unable to verify request. Secret not set in caddyfile")
+		return nil
+	}
+	return checkHMAC(signature, sha256.New, hook.Secret, body)
+}
+
+func (g GiteaHook) handlePush(body []byte, repo *Repo) error {
+	var push pushPayload
+
+	err := json.Unmarshal(body, &push)
+	if err != nil {
+		return err
+	}
+
+	return hookDispatch(g, repo, push.event())
+}
+
+func (g GiteaHook) handleRelease(body []byte, repo *Repo) error {
+	var release releasePayload
+
+	err := json.Unmarshal(body, &release)
+	if err != nil {
+		return err
+	}
+
+	if release.Release.TagName == "" {
+		return errors.New("the release request contained an invalid TagName")
+	}
+
+	return hookDispatch(g, repo, release.event())
+}
@@ -0,0 +1,124 @@
+package git
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGiteaDeployPush(t *testing.T) {
+	gtHook := GiteaHook{}
+
+	for i, test := range []struct {
+		branch string
+		body   string
+		event  string
+		code   int
+	}{
+		{"master", "", "", 400},
+		{"master", "", "push", 400},
+		{"master", pushGTBodyOther, "push", 200},
+		{"master", pushGTBodyPartial, "push", 400},
+		{"master", "", "release", 400},
+		{"master", releaseGTBody, "release", 200},
+		{"{latest}", releaseGTBody, "release", 200},
+		{"{latest}", createGTBodyTag, "create", 200},
+		{"master", "", "issues", 400},
+	} {
+		repo := &Repo{Branch: test.branch, Hook: HookConfig{URL: "/gitea_deploy"}, stopped: true}
+		req, err := http.NewRequest("POST", "/gitea_deploy", bytes.NewBuffer([]byte(test.body)))
+		if err != nil {
+			t.Fatalf("Test %v: Could not create HTTP request: %v", i, err)
+		}
+
+		if test.event != "" {
+			req.Header.Add("X-Forgejo-Event", test.event)
+		}
+
+		rec := httptest.NewRecorder()
+
+		code, err := gtHook.Handle(rec, req, repo)
+
+		if code != test.code {
+			t.Errorf("Test %d: Expected response code to be %d but was %d", i, test.code, code)
+		}
+	}
+
+	// Gitea sends X-Gogs-Event too
+	h := http.Header{}
+	h.Set("X-Gitea-Event", "push")
+	h.Set("X-Gogs-Event", "push")
+	for _, name := range defaultHandlers {
+		if handlers[name].DoesHandle(h) {
+			if name != "gitea" {
+				t.Errorf("Expected gitea handler to be detected but found %v", name)
+			}
+			break
+		}
+	}
+}
+
+var pushGTBodyPartial = `
+{
+  "ref": ""
+}
+`
+
+var pushGTBodyOther = `
+{
+  "ref": "refs/heads/some-other-branch"
+}
+`
+
+var releaseGTBody = `
+{
+  "action": "published",
+  "release": {
+    "tag_name": "v1.0.0"
+  }
+}
+`
+
+var createGTBodyTag = `
+{
+  "ref": "v1.0.0",
+  "ref_type": "tag"
+}
+`
+
+func TestGiteaSignature(t *testing.T) {
+	body := []byte(`{"ref": "refs/heads/master"}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	for i, test := range []struct {
+		header    string
+		signature string
+		body      string
+		shouldErr bool
+	}{
+		{"X-Gitea-Signature", signature, string(body), false},
+		{"X-Forgejo-Signature", signature, string(body), false},
+		{"X-Gitea-Signature", signature, `{"ref": "refs/heads/other"}`, true},
+		{"X-Gitea-Signature", "zz", string(body), true},
+		{"", "", string(body), true},
+	} {
+		req, err := http.NewRequest("POST", "/gitea_deploy", nil)
+		if err != nil {
+			t.Fatalf("Test %v: Could not create HTTP request: %v", i, err)
+		}
+		if test.header != "" {
+			req.Header.Set(test.header, test.signature)
+		}
+
+		err = GiteaHook{}.handleSignature(req, []byte(test.body), HookConfig{Secret: "secret", RequireAuth: true})
+		if test.shouldErr != (err != nil) {
+			t.Errorf("Test %v: Expected error %v but found %v", i, test.shouldErr, err)
+		}
+	}
+}
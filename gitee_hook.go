@@ -1,21 +1,19 @@
 package git
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io/ioutil"
 	"net/http"
-	"strings"
+	"time"
 )
 
 // GiteeHook is webhook for gitee.com
 type GiteeHook struct{}
 
-type giteePush struct {
-	Ref string `json:"ref"`
-}
-
 // DoesHandle satisfies hookHandler.
 func (g GiteeHook) DoesHandle(h http.Header) bool {
 	event := h.Get("X-Gitee-Event")
@@ -38,9 +36,9 @@ func (g GiteeHook) Handle(w http.ResponseWriter, r *http.Request, repo *Repo) (i
 		return http.StatusRequestTimeout, errors.New("could not read body from request")
 	}
 
-	err = g.handleToken(r, body, repo.Hook.Secret)
+	err = g.handleToken(r, body, repo.Hook)
 	if err != nil {
-		return http.StatusBadRequest, err
+		return http.StatusUnauthorized, err
 	}
 
 	event := r.Header.Get("X-Gitee-Event")
@@ -49,7 +47,7 @@ func (g GiteeHook) Handle(w http.ResponseWriter, r *http.Request, repo *Repo) (i
 	}
 
 	switch event {
-	case "Push Hook":
+	case "Push Hook", "Tag Push Hook":
 		err = g.handlePush(body, repo)
 		if !hookIgnored(err) && err != nil {
 			return http.StatusBadRequest, err
@@ -63,46 +61,47 @@ func (g GiteeHook) Handle(w http.ResponseWriter, r *http.Request, repo *Repo) (i
 	return http.StatusOK, err
 }
 
-// handleToken checks for an optional token in the request. Gitee's webhook tokens are just
-// simple strings that get sent as a header with the hook request. If one
-// exists, verify that it matches the secret in the Caddy configuration.
-func (g GiteeHook) handleToken(r *http.Request, body []byte, secret string) error {
+// handleToken checks for a token in the request. Gitee's webhook tokens are
+// either the password itself or, in signature mode, the signature of the
+// timestamp of the request and the secret. If one exists, verify that it
+// matches the secret in the Caddy configuration. The token is only
+// required if hook requires authentication.
+func (g GiteeHook) handleToken(r *http.Request, body []byte, hook HookConfig) error {
 	token := r.Header.Get("X-Gitee-Token")
-	if token != "" {
-		if secret == "" {
-			Logger().Println("unable to verify request. Secret not set in caddyfile!")
-		} else {
-			if token != secret {
-				return errors.New("unable to verify request. The token and specified secret do not match")
-			}
+	if token == "" {
+		if hook.RequireAuth {
+			return errors.New("the 'X-Gitee-Token' header is required but was missing")
 		}
+		return nil
+	}
+	if hook.Secret == "" {
+		Logger().Println("unable to verify request. Secret not set in caddyfile")
+		return nil
+	}
+	if checkToken(token, hook.Secret) == nil {
+		return nil
 	}
 
-	return nil
+	// signature mode
+	timestamp := r.Header.Get("X-Gitee-Timestamp")
+	if timestamp == "" {
+		return errors.New("unable to verify request. The token and specified secret do not match")
+	}
+	if err := checkTimestamp(r.Header, time.Now()); err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, []byte(hook.Secret))
+	mac.Write([]byte(timestamp + "\n" + hook.Secret))
+	return checkToken(token, base64.StdEncoding.EncodeToString(mac.Sum(nil)))
 }
 
 func (g GiteeHook) handlePush(body []byte, repo *Repo) error {
-	var push giteePush
+	var push pushPayload
 
 	err := json.Unmarshal(body, &push)
 	if err != nil {
 		return err
 	}
 
-	// extract the branch being pushed from the ref string
-	// and if it matches with our locally tracked one, pull.
-	refSlice := strings.Split(push.Ref, "/")
-	if len(refSlice) != 3 {
-		return errors.New("the push request contained an invalid reference string")
-	}
-
-	branch := refSlice[2]
-	if branch != repo.Branch {
-		return hookIgnoredError{hookType: hookName(g), err: fmt.Errorf("found different branch %v", branch)}
-	}
-
-	Logger().Print("Received pull notification for the tracking branch, updating...\n")
-	repo.Pull()
-
-	return nil
+	return hookDispatch(g, repo, push.event())
 }
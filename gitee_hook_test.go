@@ -2,9 +2,14 @@ package git
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
+	"time"
 )
 
 func TestGiteeDeployPush(t *testing.T) {
@@ -59,3 +64,45 @@ var pushGiteeBodyOther = `
   "ref": "refs/heads/some-other-branch"
 }
 `
+
+func TestGiteeToken(t *testing.T) {
+	sign := func(timestamp, secret string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp + "\n" + secret))
+		return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+	now := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).UnixNano()/int64(time.Millisecond), 10)
+
+	for i, test := range []struct {
+		token     string
+		timestamp string
+		shouldErr bool
+	}{
+		{"secret", "", false},
+		{"secret", now, false},
+		{"wrong", "", true},
+		{sign(now, "secret"), now, false},
+		{sign(now, "wrong"), now, true},
+		{sign(old, "secret"), now, true},
+		{sign(old, "secret"), old, true},
+		{sign(now, "secret"), "", true},
+		{"", "", true},
+	} {
+		req, err := http.NewRequest("POST", "/gitee_deploy", nil)
+		if err != nil {
+			t.Fatalf("Test %v: Could not create HTTP request: %v", i, err)
+		}
+		if test.token != "" {
+			req.Header.Set("X-Gitee-Token", test.token)
+		}
+		if test.timestamp != "" {
+			req.Header.Set("X-Gitee-Timestamp", test.timestamp)
+		}
+
+		err = GiteeHook{}.handleToken(req, nil, HookConfig{Secret: "secret", RequireAuth: true})
+		if test.shouldErr != (err != nil) {
+			t.Errorf("Test %v: Expected error %v but found %v", i, test.shouldErr, err)
+		}
+	}
+}
@@ -1,9 +1,8 @@
 package git
 
 import (
-	"crypto/hmac"
 	"crypto/sha1"
-	"encoding/hex"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -15,18 +14,6 @@ import (
 // GithubHook is webhook for Github.com.
 type GithubHook struct{}
 
-type ghRelease struct {
-	Action  string `json:"action"`
-	Release struct {
-		TagName string      `json:"tag_name"`
-		Name    interface{} `json:"name"`
-	} `json:"release"`
-}
-
-type ghPush struct {
-	Ref string `json:"ref"`
-}
-
 // DoesHandle satisfies hookHandler.
 func (g GithubHook) DoesHandle(h http.Header) bool {
 	userAgent := h.Get("User-Agent")
@@ -47,9 +34,9 @@ func (g GithubHook) Handle(w http.ResponseWriter, r *http.Request, repo *Repo) (
 	// read full body - required for signature
 	body, err := ioutil.ReadAll(r.Body)
 
-	err = g.handleSignature(r, body, repo.Hook.Secret)
+	err = g.handleSignature(r, body, repo.Hook)
 	if err != nil {
-		return http.StatusBadRequest, err
+		return http.StatusUnauthorized, err
 	}
 
 	event := r.Header.Get("X-Github-Event")
@@ -67,7 +54,7 @@ func (g GithubHook) Handle(w http.ResponseWriter, r *http.Request, repo *Repo) (
 		}
 	case "release":
 		err = g.handleRelease(body, repo)
-		if err != nil {
+		if !hookIgnored(err) && err != nil {
 			return http.StatusBadRequest, err
 		}
 
@@ -81,54 +68,47 @@ func (g GithubHook) Handle(w http.ResponseWriter, r *http.Request, repo *Repo) (
 }
 
 // Check for an optional signature in the request
-// if it is signed, verify the signature.
-func (g GithubHook) handleSignature(r *http.Request, body []byte, secret string) error {
-	signature := r.Header.Get("X-Hub-Signature")
-	if signature != "" {
-		if secret == "" {
-			Logger().Print("Unable to verify request signature. Secret not set in caddyfile!\n")
-		} else {
-			mac := hmac.New(sha1.New, []byte(secret))
-			mac.Write(body)
-			expectedMac := hex.EncodeToString(mac.Sum(nil))
-
-			if signature[5:] != expectedMac {
-				return errors.New("could not verify request signature. The signature is invalid")
-			}
+// if it is signed, verify the signature. The SHA-256 signature
+// is preferred over the legacy SHA-1 one.
+func (g GithubHook) handleSignature(r *http.Request, body []byte, hook HookConfig) error {
+	header, prefix, hash := "X-Hub-Signature-256", "sha256=", sha256.New
+	signature := r.Header.Get(header)
+	if signature == "" {
+		if hook.RequireSHA256 {
+			return errors.New("the 'X-Hub-Signature-256' header is required but was missing")
 		}
+		header, prefix, hash = "X-Hub-Signature", "sha1=", sha1.New
+		signature = r.Header.Get(header)
 	}
-
-	return nil
+	if signature == "" {
+		if hook.RequireAuth {
+			return errors.New("the 'X-Hub-Signature-256' header is required but was missing")
+		}
+		return nil
+	}
+	if hook.Secret == "" {
+		Logger().Print("Unable to verify request signature. Secret not set in caddyfile!\n")
+		return nil
+	}
+	if !strings.HasPrefix(signature, prefix) {
+		return fmt.Errorf("the '%v' header is malformed", header)
+	}
+	return checkHMAC(strings.TrimPrefix(signature, prefix), hash, hook.Secret, body)
 }
 
 func (g GithubHook) handlePush(body []byte, repo *Repo) error {
-	var push ghPush
+	var push pushPayload
 
 	err := json.Unmarshal(body, &push)
 	if err != nil {
 		return err
 	}
 
-	// extract the branch being pushed from the ref string
-	// and if it matches with our locally tracked one, pull.
-	refSlice := strings.Split(push.Ref, "/")
-	if len(refSlice) != 3 {
-		return errors.New("the push request contained an invalid reference string")
-	}
-
-	branch := refSlice[2]
-
-	if branch != repo.Branch {
-		return hookIgnoredError{hookType: hookName(g), err: fmt.Errorf("found different branch %v", branch)}
-	}
-
-	Logger().Println("Received pull notification for the tracking branch, updating...")
-	repo.Pull()
-	return nil
+	return hookDispatch(g, repo, push.event())
 }
 
 func (g GithubHook) handleRelease(body []byte, repo *Repo) error {
-	var release ghRelease
+	var release releasePayload
 
 	err := json.Unmarshal(body, &release)
 	if err != nil {
@@ -139,12 +119,5 @@ func (g GithubHook) handleRelease(body []byte, repo *Repo) error {
 		return errors.New("the release request contained an invalid TagName")
 	}
 
-	Logger().Printf("Received new release '%s'. -> Updating local repository to this release.\n", release.Release.Name)
-
-	// Update the local branch to the release tag name
-	// this will pull the release tag.
-	repo.Branch = release.Release.TagName
-	repo.Pull()
-
-	return nil
+	return hookDispatch(g, repo, release.event())
 }
@@ -2,6 +2,11 @@ package git
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -60,3 +65,49 @@ var pushBodyOther = `
   "ref": "refs/heads/some-other-branch"
 }
 `
+
+func TestGithubSignature(t *testing.T) {
+	body := []byte(`{"ref": "refs/heads/master"}`)
+	sign := func(hash func() hash.Hash, secret string) string {
+		mac := hmac.New(hash, []byte(secret))
+		mac.Write(body)
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+
+	for i, test := range []struct {
+		sha1      string
+		sha256    string
+		require   bool
+		shouldErr bool
+	}{
+		{"", "", false, false},
+		{"", "", true, true},
+		{"sha1=" + sign(sha1.New, "secret"), "", false, false},
+		{"sha1=" + sign(sha1.New, "secret"), "", true, true},
+		{"sha1=" + sign(sha1.New, "other"), "", false, true},
+		{"", "sha256=" + sign(sha256.New, "secret"), true, false},
+		{"sha1=" + sign(sha1.New, "other"), "sha256=" + sign(sha256.New, "secret"), false, false},
+		{"", "sha256=" + sign(sha256.New, "other"), false, true},
+		{"", "sha256=" + sign(sha1.New, "secret"), false, true},
+		{"sha1", "", false, true},
+		{"sha1=zz", "", false, true},
+		{"", "sha1=" + sign(sha256.New, "secret"), false, true},
+		{"", "x", false, true},
+	} {
+		req, err := http.NewRequest("POST", "/github_deploy", bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatalf("Test %v: Could not create HTTP request: %v", i, err)
+		}
+		if test.sha1 != "" {
+			req.Header.Set("X-Hub-Signature", test.sha1)
+		}
+		if test.sha256 != "" {
+			req.Header.Set("X-Hub-Signature-256", test.sha256)
+		}
+
+		err = GithubHook{}.handleSignature(req, body, HookConfig{Secret: "secret", RequireSHA256: test.require})
+		if test.shouldErr != (err != nil) {
+			t.Errorf("Test %v: Expected error %v but found %v", i, test.shouldErr, err)
+		}
+	}
+}
@@ -3,17 +3,32 @@ package git
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io/ioutil"
 	"net/http"
-	"strings"
 )
 
 // GitlabHook is webhook for gitlab.com
 type GitlabHook struct{}
 
 type glPush struct {
-	Ref string `json:"ref"`
+	Ref          string `json:"ref"`
+	Before       string `json:"before"`
+	After        string `json:"after"`
+	UserName     string `json:"user_name"`
+	UserUsername string `json:"user_username"`
+	Project      struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+		GitHTTPURL        string `json:"git_http_url"`
+		GitSSHURL         string `json:"git_ssh_url"`
+		WebURL            string `json:"web_url"`
+	} `json:"project"`
+	Commits []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		Author  struct {
+			Name string `json:"name"`
+		} `json:"author"`
+	} `json:"commits"`
 }
 
 // DoesHandle satisfies hookHandler.
@@ -38,9 +53,9 @@ func (g GitlabHook) Handle(w http.ResponseWriter, r *http.Request, repo *Repo) (
 		return http.StatusRequestTimeout, errors.New("could not read body from request")
 	}
 
-	err = g.handleToken(r, body, repo.Hook.Secret)
+	err = g.handleToken(r, body, repo.Hook)
 	if err != nil {
-		return http.StatusBadRequest, err
+		return http.StatusUnauthorized, err
 	}
 
 	event := r.Header.Get("X-Gitlab-Event")
@@ -49,7 +64,7 @@ func (g GitlabHook) Handle(w http.ResponseWriter, r *http.Request, repo *Repo) (
 	}
 
 	switch event {
-	case "Push Hook":
+	case "Push Hook", "Tag Push Hook":
 		err = g.handlePush(body, repo)
 		if !hookIgnored(err) && err != nil {
 			return http.StatusBadRequest, err
@@ -63,22 +78,23 @@ func (g GitlabHook) Handle(w http.ResponseWriter, r *http.Request, repo *Repo) (
 	return http.StatusOK, err
 }
 
-// handleToken checks for an optional token in the request. GitLab's webhook tokens are just
+// handleToken checks for a token in the request. GitLab's webhook tokens are just
 // simple strings that get sent as a header with the hook request. If one
 // exists, verify that it matches the secret in the Caddy configuration.
-func (g GitlabHook) handleToken(r *http.Request, body []byte, secret string) error {
+// The token is only required if hook requires authentication.
+func (g GitlabHook) handleToken(r *http.Request, body []byte, hook HookConfig) error {
 	token := r.Header.Get("X-Gitlab-Token")
-	if token != "" {
-		if secret == "" {
-			Logger().Println("unable to verify request. Secret not set in caddyfile")
-		} else {
-			if token != secret {
-				return errors.New("unable to verify request. The token and specified secret do not match")
-			}
+	if token == "" {
+		if hook.RequireAuth {
+			return errors.New("the 'X-Gitlab-Token' header is required but was missing")
 		}
+		return nil
 	}
-
-	return nil
+	if hook.Secret == "" {
+		Logger().Println("unable to verify request. Secret not set in caddyfile")
+		return nil
+	}
+	return checkToken(token, hook.Secret)
 }
 
 func (g GitlabHook) handlePush(body []byte, repo *Repo) error {
@@ -89,20 +105,24 @@ func (g GitlabHook) handlePush(body []byte, repo *Repo) error {
 		return err
 	}
 
-	// extract the branch being pushed from the ref string
-	// and if it matches with our locally tracked one, pull.
-	refSlice := strings.Split(push.Ref, "/")
-	if len(refSlice) != 3 {
-		return errors.New("the push request contained an invalid reference string")
-	}
+	return hookDispatch(g, repo, push.event())
+}
 
-	branch := refSlice[2]
-	if branch != repo.Branch {
-		return hookIgnoredError{hookType: hookName(g), err: fmt.Errorf("found different branch %v", branch)}
+// event returns the hook event of the push.
+func (p glPush) event() HookEvent {
+	e := newHookEvent(p.Ref)
+	e.Before, e.After = p.Before, p.After
+	e.Deleted = p.After == zeroSHA
+	e.Repo = HookRepo{
+		Name: p.Project.PathWithNamespace,
+		URLs: nonEmpty(p.Project.GitHTTPURL, p.Project.GitSSHURL, p.Project.WebURL),
 	}
-
-	Logger().Print("Received pull notification for the tracking branch, updating...\n")
-	repo.Pull()
-
-	return nil
+	e.Pusher = p.UserUsername
+	if e.Pusher == "" {
+		e.Pusher = p.UserName
+	}
+	for _, c := range p.Commits {
+		e.Commits = append(e.Commits, HookCommit{ID: c.ID, Message: c.Message, Author: c.Author.Name})
+	}
+	return e
 }
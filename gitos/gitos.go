@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"syscall"
 	"time"
 )
 
@@ -57,6 +58,9 @@ type Cmd interface {
 	// Stderr sets the process's standard output.
 	Stderr(io.Writer)
 
+	// SysProcAttr sets the OS-specific attributes of the process.
+	SysProcAttr(*syscall.SysProcAttr)
+
 	// Process is the underlying process, once started.
 	Process() *os.Process
 }
@@ -86,6 +90,11 @@ func (g *gitCmd) Stderr(stderr io.Writer) {
 	g.Cmd.Stderr = stderr
 }
 
+// SysProcAttr sets the OS-specific attributes of the process.
+func (g *gitCmd) SysProcAttr(attr *syscall.SysProcAttr) {
+	g.Cmd.SysProcAttr = attr
+}
+
 func (g *gitCmd) Process() *os.Process {
 	return g.Cmd.Process
 }
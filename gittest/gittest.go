@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/abiosoft/caddy-git/gitos"
@@ -117,6 +118,8 @@ func (f fakeCmd) Stdout(stdout io.Writer) {}
 
 func (f fakeCmd) Stderr(stderr io.Writer) {}
 
+func (f fakeCmd) SysProcAttr(attr *syscall.SysProcAttr) {}
+
 func (f fakeCmd) Process() *os.Process { return nil }
 
 // fakeInfo is a mock os.FileInfo.
@@ -1,19 +1,19 @@
 package git
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io/ioutil"
 	"net/http"
-	"strings"
 )
 
 // GogsHook is the webhook for gogs.io.
 type GogsHook struct{}
 
-type gsPush struct {
-	Ref string `json:"ref"`
+// gsSecret is the secret Gogs sends in payloads of unsigned hooks.
+type gsSecret struct {
+	Secret string `json:"secret"`
 }
 
 // DoesHandle satisfies hookHandler.
@@ -40,6 +40,11 @@ func (g GogsHook) Handle(w http.ResponseWriter, r *http.Request, repo *Repo) (in
 		return http.StatusBadRequest, err
 	}
 
+	err = g.handleSignature(r, body, repo.Hook)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+
 	event := r.Header.Get("X-Gogs-Event")
 	if event == "" {
 		return http.StatusBadRequest, errors.New("the 'X-Gogs-Event' header is required but was missing")
@@ -48,7 +53,7 @@ func (g GogsHook) Handle(w http.ResponseWriter, r *http.Request, repo *Repo) (in
 	switch event {
 	case "ping":
 		w.Write([]byte("pong"))
-	case "push":
+	case "push", "create":
 		err = g.handlePush(body, repo)
 		if !hookIgnored(err) && err != nil {
 			return http.StatusBadRequest, err
@@ -63,28 +68,39 @@ func (g GogsHook) Handle(w http.ResponseWriter, r *http.Request, repo *Repo) (in
 	return http.StatusOK, err
 }
 
+// handleSignature checks for the HMAC-SHA256 signature of the payload
+// in the request or, if not signed, the secret Gogs sends in the payload.
+// If one exists, verify that it matches the secret in the Caddy
+// configuration. It is only required if hook requires authentication.
+func (g GogsHook) handleSignature(r *http.Request, body []byte, hook HookConfig) error {
+	signature := r.Header.Get("X-Gogs-Signature")
+	var payload gsSecret
+	if signature == "" {
+		json.Unmarshal(body, &payload)
+	}
+	if signature == "" && payload.Secret == "" {
+		if hook.RequireAuth {
+			return errors.New("the 'X-Gogs-Signature' header is required but was missing")
+		}
+		return nil
+	}
+	if hook.Secret == "" {
+		Logger().Println("unable to verify request. Secret not set in caddyfile")
+		return nil
+	}
+	if signature != "" {
+		return checkHMAC(signature, sha256.New, hook.Secret, body)
+	}
+	return checkToken(payload.Secret, hook.Secret)
+}
+
 func (g GogsHook) handlePush(body []byte, repo *Repo) error {
-	var push gsPush
+	var push pushPayload
 
 	err := json.Unmarshal(body, &push)
 	if err != nil {
 		return err
 	}
 
-	// extract the branch being pushed from the ref string
-	// and if it matches with our locally tracked one, pull.
-	refSlice := strings.Split(push.Ref, "/")
-	if len(refSlice) != 3 {
-		return errors.New("the push request contained an invalid reference string")
-	}
-
-	branch := refSlice[2]
-	if branch != repo.Branch {
-		return hookIgnoredError{hookType: hookName(g), err: fmt.Errorf("found different branch %v", branch)}
-	}
-
-	Logger().Print("Received pull notification for the tracking branch, updating...\n")
-	repo.Pull()
-
-	return nil
+	return hookDispatch(g, repo, push.event())
 }
@@ -2,6 +2,9 @@ package git
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -59,3 +62,36 @@ var pushGSBodyOther = `
   "ref": "refs/heads/some-other-branch"
 }
 `
+
+func TestGogsSignature(t *testing.T) {
+	body := []byte(`{"ref": "refs/heads/master"}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	for i, test := range []struct {
+		signature string
+		body      string
+		shouldErr bool
+	}{
+		{signature, string(body), false},
+		{signature, `{"ref": "refs/heads/other"}`, true},
+		{"zz", string(body), true},
+		{"", `{"secret": "secret"}`, false},
+		{"", `{"secret": "wrong"}`, true},
+		{"", string(body), true},
+	} {
+		req, err := http.NewRequest("POST", "/gogs_deploy", nil)
+		if err != nil {
+			t.Fatalf("Test %v: Could not create HTTP request: %v", i, err)
+		}
+		if test.signature != "" {
+			req.Header.Set("X-Gogs-Signature", test.signature)
+		}
+
+		err = GogsHook{}.handleSignature(req, []byte(test.body), HookConfig{Secret: "secret", RequireAuth: true})
+		if test.shouldErr != (err != nil) {
+			t.Errorf("Test %v: Expected error %v but found %v", i, test.shouldErr, err)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package git
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// healthCheckTimeout is the timeout of a single health check request.
+const healthCheckTimeout = 10 * time.Second
+
+// HealthCheck is an http probe executed after the commands in
+// Repo.Then to confirm a successful deploy.
+type HealthCheck struct {
+	URL    string // url to probe
+	Status int    // expected response status code
+}
+
+// Check probes the health check url. It retries at most numRetries
+// times until the expected status code is returned.
+func (h HealthCheck) Check() error {
+	client := &http.Client{Timeout: healthCheckTimeout}
+
+	var err error
+	for i := 0; i < numRetries; i++ {
+		if i > 0 {
+			gos.Sleep(time.Second)
+		}
+		var resp *http.Response
+		if resp, err = client.Get(h.URL); err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode == h.Status {
+			return nil
+		}
+		err = fmt.Errorf("health check %v expected status %d but found %d", h.URL, h.Status, resp.StatusCode)
+	}
+	return err
+}
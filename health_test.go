@@ -0,0 +1,34 @@
+package git
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	for i, test := range []struct {
+		check     HealthCheck
+		shouldErr bool
+	}{
+		{HealthCheck{URL: server.URL + "/healthz", Status: 200}, false},
+		{HealthCheck{URL: server.URL + "/down", Status: 200}, true},
+		{HealthCheck{URL: server.URL + "/down", Status: 503}, false},
+		{HealthCheck{URL: "http://127.0.0.1:0/", Status: 200}, true},
+	} {
+		err := test.check.Check()
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %v: Expected error but found nil", i)
+		}
+		if !test.shouldErr && err != nil {
+			t.Errorf("Test %v: Expected no error but found %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package git
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// maxHookJobs is the maximum number of hook jobs kept.
+// The oldest finished jobs are removed first.
+const maxHookJobs = 100
+
+// Hook job statuses.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// HookJob is a pull executed in background in response to a webhook.
+type HookJob struct {
+	ID       string        `json:"id"`
+	Repo     string        `json:"repo"`
+	Status   string        `json:"status"`
+	Created  time.Time     `json:"created"`
+	Started  time.Time     `json:"started,omitempty"`
+	Finished time.Time     `json:"finished,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Deploy   *DeployResult `json:"deploy,omitempty"`
+}
+
+// Jobs holds the recent hook jobs.
+var Jobs = &hookJobs{jobs: make(map[string]*HookJob)}
+
+// hookJobs stores hook jobs by id.
+type hookJobs struct {
+	jobs  map[string]*HookJob
+	order []string // ids from oldest to newest
+	sync.Mutex
+}
+
+// start creates a job for repo and executes pull in background. pull
+// calls started once it actually starts, the job is queued until then.
+func (h *hookJobs) start(repo *Repo, pull func(started func()) error) (HookJob, error) {
+	id, err := newJobID()
+	if err != nil {
+		return HookJob{}, err
+	}
+	job := &HookJob{ID: id, Repo: repo.ID(), Status: JobQueued, Created: time.Now()}
+	h.add(job)
+	created := *job
+
+	go func() {
+		err := pull(func() {
+			h.update(id, func(job *HookJob) {
+				job.Status = JobRunning
+				job.Started = time.Now()
+			})
+		})
+		deploy := repo.Status().LastDeploy
+		h.update(id, func(job *HookJob) {
+			job.Finished = time.Now()
+			job.Status = JobSucceeded
+			if err != nil {
+				job.Status = JobFailed
+				job.Error = err.Error()
+			}
+			// only a deploy done by this job
+			if deploy != nil && deploy.Time.After(job.Started) {
+				job.Deploy = deploy
+			}
+		})
+	}()
+	return created, nil
+}
+
+// add adds job, removing the oldest finished jobs if full.
+func (h *hookJobs) add(job *HookJob) {
+	h.Lock()
+	defer h.Unlock()
+
+	for i := 0; len(h.order) >= maxHookJobs && i < len(h.order); {
+		old := h.jobs[h.order[i]]
+		if old.Status != JobSucceeded && old.Status != JobFailed {
+			i++
+			continue
+		}
+		delete(h.jobs, old.ID)
+		h.order = append(h.order[:i], h.order[i+1:]...)
+	}
+	h.jobs[job.ID] = job
+	h.order = append(h.order, job.ID)
+}
+
+// update modifies the job with id using fn.
+func (h *hookJobs) update(id string, fn func(*HookJob)) {
+	h.Lock()
+	defer h.Unlock()
+	if job, ok := h.jobs[id]; ok {
+		fn(job)
+	}
+}
+
+// Get returns the job with id.
+func (h *hookJobs) Get(id string) (HookJob, bool) {
+	h.Lock()
+	defer h.Unlock()
+	job, ok := h.jobs[id]
+	if !ok {
+		return HookJob{}, false
+	}
+	return *job, true
+}
+
+// owns checks if the job with id belongs to repo.
+func (h *hookJobs) owns(repo *Repo, id string) bool {
+	job, ok := h.Get(id)
+	return ok && job.Repo == repo.ID()
+}
+
+// newJobID generates a random job id.
+func newJobID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
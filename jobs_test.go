@@ -0,0 +1,106 @@
+package git
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/abiosoft/caddy-git/gittest"
+	"github.com/caddyserver/caddy/caddyhttp/httpserver"
+)
+
+func TestHookJobs(t *testing.T) {
+	SetLogger(gittest.NewLogger(gittest.Open("file")))
+
+	repo := createRepo(&Repo{})
+	repo.Hook = HookConfig{URL: "/hook", Secret: "secret", Type: "generic", Async: true}
+	check(t, repo.Prepare())
+	hook := WebHook{Repos: []*Repo{repo}, Next: httpserver.HandlerFunc(func(w http.ResponseWriter, r *http.Request) (int, error) {
+		return http.StatusNotFound, nil
+	})}
+
+	// hook responds right away with the job,
+	// which waits for a pull in progress
+	repo.flight.Lock()
+	repo.flight.running = true
+	repo.flight.Unlock()
+	repo.Lock()
+	req, err := http.NewRequest("POST", "/hook", bytes.NewBufferString(`{"ref": "refs/heads/master"}`))
+	check(t, err)
+	rec := httptest.NewRecorder()
+	code, err := hook.ServeHTTP(rec, req)
+	check(t, err)
+	if code != http.StatusAccepted || rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected status %v, found %v", http.StatusAccepted, code)
+	}
+	var job HookJob
+	check(t, json.Unmarshal(rec.Body.Bytes(), &job))
+	if job.ID == "" || job.Repo != repo.ID() || job.Status != JobQueued {
+		t.Errorf("Expected queued job, found %+v", job)
+	}
+	if location := rec.Header().Get("Location"); location != "/hook/jobs/"+job.ID {
+		t.Errorf("Expected job location, found %v", location)
+	}
+	repo.Unlock()
+
+	status := func(id, token string) (int, HookJob) {
+		req, err := http.NewRequest("GET", "/hook/jobs/"+id, nil)
+		check(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		code, _ := hook.ServeHTTP(rec, req)
+		var job HookJob
+		json.Unmarshal(rec.Body.Bytes(), &job)
+		return code, job
+	}
+
+	if code, _ := status(job.ID, ""); code != http.StatusUnauthorized {
+		t.Errorf("Expected status %v, found %v", http.StatusUnauthorized, code)
+	}
+	if code, _ := status("unknown", "secret"); code != http.StatusNotFound {
+		t.Errorf("Expected status %v, found %v", http.StatusNotFound, code)
+	}
+
+	// job is queued until the pull starts
+	time.Sleep(time.Millisecond * 50)
+	if _, found := status(job.ID, "secret"); found.Status != JobQueued {
+		t.Errorf("Expected queued job, found %+v", found)
+	}
+	go repo.trailingPulls(repo.nextPull())
+
+	for i := 0; ; i++ {
+		code, found := status(job.ID, "secret")
+		if code != http.StatusOK {
+			t.Fatalf("Expected status %v, found %v", http.StatusOK, code)
+		}
+		if found.Status == JobSucceeded {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("Expected job to succeed, found %+v", found)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestHookJobsLimit(t *testing.T) {
+	jobs := &hookJobs{jobs: make(map[string]*HookJob)}
+	running := &HookJob{ID: "running", Status: JobRunning}
+	jobs.add(running)
+	for i := 0; i < maxHookJobs+10; i++ {
+		id, err := newJobID()
+		check(t, err)
+		jobs.add(&HookJob{ID: id, Status: JobSucceeded})
+	}
+	if len(jobs.jobs) != maxHookJobs || len(jobs.order) != maxHookJobs {
+		t.Errorf("Expected %v jobs, found %v", maxHookJobs, len(jobs.jobs))
+	}
+	if _, ok := jobs.Get("running"); !ok {
+		t.Errorf("Expected unfinished job to be kept")
+	}
+}
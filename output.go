@@ -0,0 +1,173 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// defaultTailLines is the number of output lines of a command
+	// kept in memory.
+	defaultTailLines = 20
+
+	// defaultLogSize is the size in megabytes a log file is rotated at.
+	defaultLogSize = 10
+
+	// defaultLogBackups is the number of rotated log files kept.
+	defaultLogBackups = 3
+)
+
+// lineWriter is an io.Writer that calls fn with every complete
+// line written to it.
+type lineWriter struct {
+	fn  func(line string)
+	buf []byte
+	sync.Mutex
+}
+
+// Write satisfies io.Writer.
+func (l *lineWriter) Write(b []byte) (int, error) {
+	l.Lock()
+	defer l.Unlock()
+	l.buf = append(l.buf, b...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		l.fn(strings.TrimSuffix(string(l.buf[:i]), "\r"))
+		l.buf = l.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+// tailBuffer keeps the last lines added to it.
+type tailBuffer struct {
+	size  int
+	lines []string
+	sync.Mutex
+}
+
+// add adds line to the buffer and drops the oldest line if full.
+func (t *tailBuffer) add(line string) {
+	t.Lock()
+	defer t.Unlock()
+	if t.size <= 0 {
+		return
+	}
+	if len(t.lines) == t.size {
+		t.lines = append(t.lines[:0], t.lines[1:]...)
+	}
+	t.lines = append(t.lines, line)
+}
+
+// Lines returns a copy of the lines in the buffer.
+func (t *tailBuffer) Lines() []string {
+	t.Lock()
+	defer t.Unlock()
+	return append([]string(nil), t.lines...)
+}
+
+// rotatingFile is an io.Writer that writes to a log file and rotates it
+// once it exceeds maxSize. The rotated files are named path.1 up to
+// path.backups, path.1 being the most recent.
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	backups int
+
+	file *os.File
+	size int64
+	sync.Mutex
+}
+
+// Write satisfies io.Writer.
+func (r *rotatingFile) Write(b []byte) (int, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.file != nil && r.size+int64(len(b)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	if r.file == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(b)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close closes the log file. It is opened again on the next write.
+func (r *rotatingFile) Close() error {
+	r.Lock()
+	defer r.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// open opens the log file for appending.
+func (r *rotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(r.path), os.FileMode(0755)); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, os.FileMode(0644))
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file, r.size = file, info.Size()
+	return nil
+}
+
+// rotate closes the log file and shifts it along with the backups.
+// The oldest backup is removed.
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+
+	backup := func(i int) string {
+		return fmt.Sprintf("%v.%d", r.path, i)
+	}
+	os.Remove(backup(r.backups))
+	for i := r.backups - 1; i > 0; i-- {
+		os.Rename(backup(i), backup(i+1))
+	}
+	if r.backups > 0 {
+		return os.Rename(r.path, backup(1))
+	}
+	return os.Remove(r.path)
+}
+
+// commandError is returned when a command fails. It includes the
+// last lines of output of the command.
+type commandError struct {
+	command string
+	err     error
+	output  []string
+}
+
+// Error satisfies error interface
+func (c commandError) Error() string {
+	if len(c.output) == 0 {
+		return fmt.Sprintf("command '%v' failed. Error: %v", c.command, c.err)
+	}
+	return fmt.Sprintf("command '%v' failed. Error: %v. Output:\n%v", c.command, c.err, strings.Join(c.output, "\n"))
+}
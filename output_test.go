@@ -0,0 +1,109 @@
+package git
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLineWriter(t *testing.T) {
+	var lines []string
+	w := &lineWriter{fn: func(line string) {
+		lines = append(lines, line)
+	}}
+	w.Write([]byte("starting\r\nlisten"))
+	w.Write([]byte("ing on :8080\n\npartial"))
+
+	expected := []string{"starting", "listening on :8080", ""}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %v lines found %v", len(expected), lines)
+	}
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Errorf("Expected line %v to be '%v' found '%v'", i, expected[i], lines[i])
+		}
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	tail := &tailBuffer{size: 3}
+	for _, line := range []string{"1", "2", "3", "4", "5"} {
+		tail.add(line)
+	}
+	if lines := tail.Lines(); len(lines) != 3 || lines[0] != "3" || lines[2] != "5" {
+		t.Errorf("Expected last 3 lines found %v", lines)
+	}
+
+	tail = &tailBuffer{}
+	tail.add("1")
+	if lines := tail.Lines(); len(lines) != 0 {
+		t.Errorf("Expected no lines found %v", lines)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "caddy-git")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "logs", "app.log")
+	f := &rotatingFile{path: path, maxSize: 10, backups: 2}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("Could not write: %v", err)
+		}
+	}
+	check(t, f.Close())
+	if f.file != nil {
+		t.Errorf("Expected log file to be closed")
+	}
+
+	for file, expected := range map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	} {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Errorf("Could not read %v: %v", file, err)
+			continue
+		}
+		if string(b) != expected {
+			t.Errorf("Expected %v to contain %q found %q", file, expected, string(b))
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected at most 2 backups")
+	}
+
+	// the log file of a repo is closed on shutdown and opened again
+	g := newLongCmd("echo")
+	g.log = f
+	repo := &Repo{Then: []Then{g}}
+	if _, err := f.Write([]byte("fifth\n")); err != nil {
+		t.Fatalf("Could not write: %v", err)
+	}
+	check(t, repo.shutdown())
+	if f.file != nil {
+		t.Errorf("Expected log file to be closed on shutdown")
+	}
+	if _, err := f.Write([]byte("sixth\n")); err != nil {
+		t.Fatalf("Could not write after shutdown: %v", err)
+	}
+	check(t, f.Close())
+	if b, _ := ioutil.ReadFile(path); string(b) != "fourth\nfifth\nsixth\n" {
+		t.Errorf("Expected log file to be appended to, found %q", string(b))
+	}
+}
+
+func TestCommandError(t *testing.T) {
+	err := commandError{command: "make", err: errors.New("exit status 2"), output: []string{"compiling", "error: missing file"}}
+	expected := "command 'make' failed. Error: exit status 2. Output:\ncompiling\nerror: missing file"
+	if err.Error() != expected {
+		t.Errorf("Expected %q found %q", expected, err.Error())
+	}
+}
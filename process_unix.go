@@ -0,0 +1,55 @@
+// +build !windows
+
+package git
+
+import (
+	"os"
+	"strings"
+	"syscall"
+)
+
+// defaultStopSignal is the signal sent to stop then_long commands.
+var defaultStopSignal os.Signal = syscall.SIGTERM
+
+// stopSignals are the signals that can be configured to stop
+// then_long commands.
+var stopSignals = map[string]os.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+	"SIGTERM": syscall.SIGTERM,
+}
+
+// processGroupAttr returns the attributes to start a process
+// in its own process group.
+func processGroupAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true}
+}
+
+// signalGroup sends sig to all processes in the process group of p.
+func signalGroup(p *os.Process, sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return p.Signal(sig)
+	}
+	return syscall.Kill(-p.Pid, s)
+}
+
+// groupRunning checks if any process in the process group of p
+// is still running.
+func groupRunning(p *os.Process) bool {
+	return syscall.Kill(-p.Pid, 0) == nil
+}
+
+// lookupSignal returns the signal named name e.g. SIGINT or INT.
+func lookupSignal(name string) (os.Signal, bool) {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig, ok := stopSignals[name]
+	return sig, ok
+}
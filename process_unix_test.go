@@ -0,0 +1,133 @@
+// +build !windows
+
+package git
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/abiosoft/caddy-git/gittest"
+)
+
+func TestHaltProcessGroup(t *testing.T) {
+	SetLogger(gittest.NewLogger(gittest.Open("file")))
+
+	for i, test := range []struct {
+		script  string
+		signal  syscall.Signal
+		maxWait time.Duration
+	}{
+		// children exit on stop signal
+		{"sleep 30 & wait", syscall.SIGTERM, time.Second * 2},
+		// children ignore stop signal and are killed after timeout
+		{"trap '' TERM; sleep 30 & wait", syscall.SIGTERM, time.Second * 2},
+		{"sleep 30 & wait", syscall.SIGINT, time.Second * 2},
+	} {
+		cmd := exec.Command("sh", "-c", test.script)
+		cmd.SysProcAttr = processGroupAttr()
+		if err := cmd.Start(); err != nil {
+			t.Fatalf("Test %v: Could not start command: %v", i, err)
+		}
+
+		g := newLongCmd("sh", "-c", test.script)
+		g.stopSignal = test.signal
+		g.stopTimeout = time.Millisecond * 500
+		g.process = cmd.Process
+		g.monitorProcess()
+
+		// give the shell time to spawn its children
+		time.Sleep(time.Millisecond * 100)
+
+		start := time.Now()
+		g.haltProcess()
+		if time.Since(start) > test.maxWait {
+			t.Errorf("Test %v: Expected halt within %v but took %v", i, test.maxWait, time.Since(start))
+		}
+		if groupAlive(t, cmd.Process.Pid) {
+			t.Errorf("Test %v: Expected process group to be stopped", i)
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		}
+		if g.process != nil || g.monitoring {
+			t.Errorf("Test %v: Expected process to be cleared", i)
+		}
+	}
+}
+
+// groupAlive checks if any process in the process group pgid is
+// alive. Zombies are ignored as reaping them is up to init.
+func groupAlive(t *testing.T, pgid int) bool {
+	out, err := exec.Command("ps", "-eo", "pgid=,stat=").Output()
+	if err != nil {
+		t.Fatalf("Could not list processes: %v", err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != strconv.Itoa(pgid) {
+			continue
+		}
+		if !strings.HasPrefix(fields[1], "Z") {
+			return true
+		}
+	}
+	return false
+}
+
+func TestExitedProcessGroup(t *testing.T) {
+	SetLogger(gittest.NewLogger(gittest.Open("file")))
+
+	// the child ignores the stop signal and survives its parent
+	script := "trap '' TERM; sleep 30 & exit 1"
+	cmd := exec.Command("sh", "-c", script)
+	cmd.SysProcAttr = processGroupAttr()
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Could not start command: %v", err)
+	}
+
+	g := newLongCmd("sh", "-c", script)
+	g.restart.mode = "never"
+	g.stopTimeout = time.Millisecond * 500
+	g.process = cmd.Process
+	done := g.monitorProcess()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatalf("Expected monitoring to stop after exit")
+	}
+	if groupAlive(t, cmd.Process.Pid) {
+		t.Errorf("Expected process group to be stopped after the command exited")
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+func TestCrashLoop(t *testing.T) {
+	SetLogger(gittest.NewLogger(gittest.Open("file")))
+
+	cmd := exec.Command("sh", "-c", "exit 1")
+	cmd.SysProcAttr = processGroupAttr()
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Could not start command: %v", err)
+	}
+
+	// restarts fail as the fake OS starts no process
+	g := newLongCmd("sh", "-c", "exit 1")
+	g.restart.delay = time.Millisecond
+	g.restart.limit = 3
+	g.process = cmd.Process
+	done := g.monitorProcess()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatalf("Expected restarts to stop after crash loop")
+	}
+
+	status := g.status()
+	if !status.CrashLoop || status.Running || status.Restarts != 3 {
+		t.Errorf("Expected crash loop after 3 restarts but found %+v", status)
+	}
+}
@@ -0,0 +1,39 @@
+package git
+
+import (
+	"os"
+	"strings"
+	"syscall"
+)
+
+// defaultStopSignal is the signal sent to stop then_long commands.
+// Windows does not support other signals.
+var defaultStopSignal = os.Kill
+
+// processGroupAttr returns the attributes to start a process
+// in its own process group.
+func processGroupAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// signalGroup sends sig to p. Process groups cannot be signalled
+// on Windows.
+func signalGroup(p *os.Process, sig os.Signal) error {
+	return p.Signal(sig)
+}
+
+// groupRunning checks if any process in the process group of p
+// is still running. It is unknown on Windows.
+func groupRunning(p *os.Process) bool {
+	return false
+}
+
+// lookupSignal returns the signal named name. Only SIGKILL is
+// supported on Windows.
+func lookupSignal(name string) (os.Signal, bool) {
+	switch strings.ToUpper(name) {
+	case "SIGKILL", "KILL":
+		return os.Kill, true
+	}
+	return nil, false
+}
@@ -0,0 +1,77 @@
+package git
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// defaultReadyTimeout is the time to wait for a then_long command
+// to become ready.
+const defaultReadyTimeout = 30 * time.Second
+
+// readyInterval is the interval between readiness checks.
+const readyInterval = 250 * time.Millisecond
+
+// readyProbe checks if a then_long command is ready to serve.
+type readyProbe struct {
+	kind    string         // tcp, http or log
+	address string         // tcp address or http url
+	status  int            // expected http status code
+	pattern *regexp.Regexp // log line to wait for
+	timeout time.Duration  // time to wait for the command to be ready
+}
+
+// wait waits until the command is ready or the timeout elapses.
+// logged is closed once the command logs a line matching the
+// pattern and stopped is closed if the command stops.
+func (p *readyProbe) wait(logged, stopped <-chan struct{}) error {
+	timeout := time.After(p.timeout)
+	for {
+		if p.kind != "log" && p.check() == nil {
+			return nil
+		}
+		select {
+		case <-logged:
+			return nil
+		case <-stopped:
+			return fmt.Errorf("command stopped")
+		case <-timeout:
+			if p.kind == "log" {
+				return fmt.Errorf("no log line matching '%v' within %v", p.pattern, p.timeout)
+			}
+			return fmt.Errorf("%v within %v", p.check(), p.timeout)
+		case <-time.After(readyInterval):
+		}
+	}
+}
+
+// check checks the tcp or http probe once.
+func (p *readyProbe) check() error {
+	switch p.kind {
+	case "tcp":
+		network, address := "tcp", p.address
+		if strings.HasPrefix(address, "unix:") {
+			network, address = "unix", strings.TrimPrefix(address, "unix:")
+		}
+		conn, err := net.DialTimeout(network, address, readyInterval)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case "http":
+		client := &http.Client{Timeout: readyInterval * 4}
+		resp, err := client.Get(p.address)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != p.status {
+			return fmt.Errorf("%v expected status %d but found %d", p.address, p.status, resp.StatusCode)
+		}
+	}
+	return nil
+}
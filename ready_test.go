@@ -0,0 +1,61 @@
+package git
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestReadyProbe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ready" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	defer listener.Close()
+	closed.Close()
+
+	logged := make(chan struct{})
+	close(logged)
+	stopped := make(chan struct{})
+	close(stopped)
+
+	timeout := time.Millisecond * 500
+	for i, test := range []struct {
+		probe     readyProbe
+		logged    chan struct{}
+		stopped   chan struct{}
+		shouldErr bool
+	}{
+		{readyProbe{kind: "tcp", address: listener.Addr().String()}, nil, nil, false},
+		{readyProbe{kind: "tcp", address: closed.Addr().String()}, nil, nil, true},
+		{readyProbe{kind: "http", address: server.URL + "/ready", status: 200}, nil, nil, false},
+		{readyProbe{kind: "http", address: server.URL + "/starting", status: 200}, nil, nil, true},
+		{readyProbe{kind: "log"}, logged, nil, false},
+		{readyProbe{kind: "log"}, nil, nil, true},
+		{readyProbe{kind: "log"}, nil, stopped, true},
+	} {
+		test.probe.timeout = timeout
+		test.probe.pattern = regexp.MustCompile("listening")
+		err := test.probe.wait(test.logged, test.stopped)
+		if test.shouldErr && err == nil {
+			t.Errorf("Test %v: Expected error but found nil", i)
+		}
+		if !test.shouldErr && err != nil {
+			t.Errorf("Test %v: Expected no error but found %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package git
+
+import (
+	"time"
+)
+
+// Restart modes of then_long commands.
+const (
+	restartAlways    = "always"
+	restartOnFailure = "on-failure"
+	restartNever     = "never"
+)
+
+// defaultRestartPolicy is the restart policy of then_long commands
+// if not configured.
+var defaultRestartPolicy = restartPolicy{
+	mode:     restartOnFailure,
+	delay:    time.Second,
+	maxDelay: time.Minute,
+	limit:    5,
+	window:   time.Minute,
+	reset:    30 * time.Second,
+}
+
+// restartPolicy decides if and when a terminated then_long
+// command is restarted.
+type restartPolicy struct {
+	mode     string        // always, on-failure or never
+	delay    time.Duration // delay before the first restart
+	maxDelay time.Duration // maximum delay between restarts
+	limit    int           // maximum number of restarts within window
+	window   time.Duration // window to count restarts in
+	reset    time.Duration // uptime after which the delay is reset
+}
+
+// restarts checks if a command that terminated with or
+// without failure should be restarted.
+func (p restartPolicy) restarts(failed bool) bool {
+	switch p.mode {
+	case restartAlways:
+		return true
+	case restartOnFailure:
+		return failed
+	}
+	return false
+}
+
+// restartBackoff keeps track of the restarts of a then_long command.
+type restartBackoff struct {
+	delay    time.Duration // delay before the previous restart
+	restarts []time.Time   // restarts within the window
+	total    int           // total number of restarts
+}
+
+// next returns the delay before the next restart of a command that
+// ran for uptime. The delay doubles on every restart until the command
+// stays up for the reset duration. It returns false if the command
+// exceeded the restart limit.
+func (b *restartBackoff) next(p restartPolicy, uptime time.Duration, now time.Time) (time.Duration, bool) {
+	if uptime >= p.reset {
+		b.delay = 0
+	}
+
+	// forget restarts outside the window
+	restarts := b.restarts[:0]
+	for _, t := range b.restarts {
+		if now.Sub(t) < p.window {
+			restarts = append(restarts, t)
+		}
+	}
+	b.restarts = restarts
+	if len(b.restarts) >= p.limit {
+		return 0, false
+	}
+
+	switch {
+	case b.delay == 0:
+		b.delay = p.delay
+	case b.delay*2 > p.maxDelay:
+		b.delay = p.maxDelay
+	default:
+		b.delay *= 2
+	}
+	b.restarts = append(b.restarts, now)
+	b.total++
+	return b.delay, true
+}
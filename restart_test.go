@@ -0,0 +1,65 @@
+package git
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestartPolicy(t *testing.T) {
+	for i, test := range []struct {
+		mode     string
+		failed   bool
+		restarts bool
+	}{
+		{restartAlways, true, true},
+		{restartAlways, false, true},
+		{restartOnFailure, true, true},
+		{restartOnFailure, false, false},
+		{restartNever, true, false},
+		{restartNever, false, false},
+	} {
+		p := restartPolicy{mode: test.mode}
+		if p.restarts(test.failed) != test.restarts {
+			t.Errorf("Test %v: Expected restart %v for %v but found %v", i, test.restarts, test.mode, !test.restarts)
+		}
+	}
+}
+
+func TestRestartBackoff(t *testing.T) {
+	p := restartPolicy{
+		delay:    time.Second,
+		maxDelay: time.Second * 5,
+		limit:    4,
+		window:   time.Minute,
+		reset:    time.Second * 30,
+	}
+	b := &restartBackoff{}
+	now := time.Now()
+
+	for i, test := range []struct {
+		uptime time.Duration
+		after  time.Duration
+		delay  time.Duration
+		ok     bool
+	}{
+		{0, 0, time.Second, true},
+		{0, time.Second, time.Second * 2, true},
+		{0, time.Second, time.Second * 4, true},
+		// capped at max delay
+		{0, time.Second, time.Second * 5, true},
+		// limit exceeded within window
+		{0, time.Second, 0, false},
+		// stable uptime resets the delay, window moved on
+		{time.Second * 30, time.Minute, time.Second, true},
+		{0, time.Second, time.Second * 2, true},
+	} {
+		now = now.Add(test.after)
+		delay, ok := b.next(p, test.uptime, now)
+		if delay != test.delay || ok != test.ok {
+			t.Errorf("Test %v: Expected %v %v found %v %v", i, test.delay, test.ok, delay, ok)
+		}
+	}
+	if b.total != 6 {
+		t.Errorf("Expected %v restarts found %v", 6, b.total)
+	}
+}
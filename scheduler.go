@@ -0,0 +1,153 @@
+package git
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Scheduler runs the pulls of all repositories with a limited
+// number of workers.
+var Scheduler = newPullScheduler(runtime.NumCPU())
+
+// QueuedPull is a pull waiting for or running on a worker.
+type QueuedPull struct {
+	Repo     string    // Repository id
+	Triggers []string  // Triggers of the pull
+	Priority bool      // true for webhook and manual pulls
+	Queued   time.Time // Time the pull was queued
+	Started  time.Time // Time the pull started, zero if waiting
+}
+
+// pullTask is a pull scheduled to run.
+type pullTask struct {
+	QueuedPull
+	seq   int // order of queueing
+	start chan struct{}
+}
+
+// pullScheduler runs queued pulls by priority. Pulls of the same
+// priority run in turns between repositories and in order of queueing
+// for a repository.
+type pullScheduler struct {
+	workers int
+	seq     int
+	turn    int
+	turns   map[string]int // turn a repository last ran at
+	queue   []*pullTask
+	running []*pullTask
+	sync.Mutex
+}
+
+// newPullScheduler creates a pullScheduler with workers.
+func newPullScheduler(workers int) *pullScheduler {
+	if workers < 1 {
+		workers = 1
+	}
+	return &pullScheduler{workers: workers, turns: make(map[string]int)}
+}
+
+// SetWorkers sets the number of pulls that can run at the same time.
+func (s *pullScheduler) SetWorkers(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.workers = workers
+	s.dispatch()
+}
+
+// Workers returns the number of pulls that can run at the same time.
+func (s *pullScheduler) Workers() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.workers
+}
+
+// run queues pull of repo and waits for a worker to execute it.
+func (s *pullScheduler) run(repo *Repo, triggers []string, pull func() error) error {
+	task := &pullTask{
+		QueuedPull: QueuedPull{Repo: repo.ID(), Triggers: triggers, Queued: time.Now()},
+		start:      make(chan struct{}),
+	}
+	for _, t := range triggers {
+		if t == TriggerWebhook || t == TriggerManual {
+			task.Priority = true
+		}
+	}
+
+	s.Lock()
+	s.seq++
+	task.seq = s.seq
+	s.queue = append(s.queue, task)
+	s.dispatch()
+	s.Unlock()
+
+	<-task.start
+	defer s.finish(task)
+	return pull()
+}
+
+// dispatch starts queued tasks while workers are available.
+// s must be locked.
+func (s *pullScheduler) dispatch() {
+	for len(s.running) < s.workers && len(s.queue) > 0 {
+		next := 0
+		for i := range s.queue {
+			if s.before(s.queue[i], s.queue[next]) {
+				next = i
+			}
+		}
+		task := s.queue[next]
+		s.queue = append(s.queue[:next], s.queue[next+1:]...)
+
+		s.turn++
+		s.turns[task.Repo] = s.turn
+		task.Started = time.Now()
+		s.running = append(s.running, task)
+		close(task.start)
+	}
+}
+
+// before checks if task a is to run before task b.
+func (s *pullScheduler) before(a, b *pullTask) bool {
+	if a.Priority != b.Priority {
+		return a.Priority
+	}
+	if s.turns[a.Repo] != s.turns[b.Repo] {
+		return s.turns[a.Repo] < s.turns[b.Repo]
+	}
+	return a.seq < b.seq
+}
+
+// finish releases the worker of task.
+func (s *pullScheduler) finish(task *pullTask) {
+	s.Lock()
+	defer s.Unlock()
+	for i, t := range s.running {
+		if t == task {
+			s.running = append(s.running[:i], s.running[i+1:]...)
+			break
+		}
+	}
+	s.dispatch()
+}
+
+// Queue returns the running pulls followed by the waiting pulls
+// in the order they are to run.
+func (s *pullScheduler) Queue() []QueuedPull {
+	s.Lock()
+	defer s.Unlock()
+
+	waiting := append([]*pullTask{}, s.queue...)
+	sort.Slice(waiting, func(i, j int) bool {
+		return s.before(waiting[i], waiting[j])
+	})
+	var pulls []QueuedPull
+	for _, task := range append(append([]*pullTask{}, s.running...), waiting...) {
+		pulls = append(pulls, task.QueuedPull)
+	}
+	return pulls
+}
@@ -0,0 +1,77 @@
+package git
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	s := newPullScheduler(1)
+
+	var order []string
+	var mu sync.Mutex
+	done := make(chan struct{}, 10)
+	release := make(chan struct{})
+	pull := func(name string, triggers ...string) {
+		go func() {
+			s.run(&Repo{Name: name}, triggers, func() error {
+				mu.Lock()
+				order = append(order, fmt.Sprint(name, triggers))
+				mu.Unlock()
+				<-release
+				return nil
+			})
+			done <- struct{}{}
+		}()
+	}
+	waitQueue := func(n int) {
+		for len(s.Queue()) != n {
+			time.Sleep(time.Millisecond * 10)
+		}
+	}
+
+	// a occupies the only worker
+	pull("a", TriggerInterval)
+	waitQueue(1)
+	pull("a", TriggerInterval)
+	waitQueue(2)
+	pull("b", TriggerInterval)
+	waitQueue(3)
+	pull("c", TriggerWebhook)
+	waitQueue(4)
+
+	queue := s.Queue()
+	if queue[0].Repo != "a" || queue[0].Started.IsZero() {
+		t.Errorf("Expected running pull first, found %+v", queue[0])
+	}
+	if queue[1].Repo != "c" || !queue[1].Priority || !queue[1].Started.IsZero() {
+		t.Errorf("Expected waiting webhook pull next, found %+v", queue[1])
+	}
+
+	for i := 0; i < 4; i++ {
+		release <- struct{}{}
+		<-done
+	}
+	expected := "[a[interval] c[webhook] b[interval] a[interval]]"
+	mu.Lock()
+	if fmt.Sprint(order) != expected {
+		t.Errorf("Expected order %v, found %v", expected, order)
+	}
+	mu.Unlock()
+
+	// more workers run pulls at the same time
+	s.SetWorkers(2)
+	pull("a", TriggerInterval)
+	pull("b", TriggerInterval)
+	for len(s.Queue()) != 2 || s.Queue()[1].Started.IsZero() {
+		time.Sleep(time.Millisecond * 10)
+	}
+	close(release)
+	<-done
+	<-done
+	if len(s.Queue()) != 0 {
+		t.Errorf("Expected empty queue, found %v", s.Queue())
+	}
+}
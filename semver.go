@@ -0,0 +1,174 @@
+package git
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// semver is a semantic version of a tag e.g. v1.2.3.
+type semver struct {
+	major, minor, patch int
+	pre                 string // pre-release e.g. beta.1
+}
+
+// parseSemver parses a version with optional v prefix. Missing minor
+// and patch versions are zero and parts reports how many are present.
+func parseSemver(s string) (v semver, parts int, ok bool) {
+	s = strings.TrimPrefix(s, "v")
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		s, v.pre = s[:i], s[i+1:]
+	}
+	nums := strings.Split(s, ".")
+	if len(nums) > 3 {
+		return v, 0, false
+	}
+	for i, n := range nums {
+		num, err := strconv.Atoi(n)
+		if err != nil || num < 0 {
+			return v, 0, false
+		}
+		switch i {
+		case 0:
+			v.major = num
+		case 1:
+			v.minor = num
+		case 2:
+			v.patch = num
+		}
+	}
+	return v, len(nums), true
+}
+
+// compare returns -1, 0 or 1 if v is lower, equal or higher than o.
+func (v semver) compare(o semver) int {
+	for _, d := range []int{v.major - o.major, v.minor - o.minor, v.patch - o.patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	switch {
+	case v.pre == o.pre:
+		return 0
+	case v.pre == "":
+		return 1
+	case o.pre == "":
+		return -1
+	case v.pre < o.pre:
+		return -1
+	}
+	return 1
+}
+
+// semverRange is a range of versions with min inclusive and max
+// exclusive, nil if unbounded.
+type semverRange struct {
+	min, max         *semver
+	minOpen, maxOpen bool // min exclusive, max inclusive
+}
+
+// semverConstraint is a version constraint e.g. "^1.2" or ">=1.0 <2".
+// A version must be within all ranges.
+type semverConstraint []semverRange
+
+// isSemverConstraint checks if branch is a version constraint. These
+// start with characters not allowed or unusual in git branch names.
+func isSemverConstraint(branch string) bool {
+	return branch != "" && strings.ContainsRune("^~<>=", rune(branch[0]))
+}
+
+// parseSemverConstraint parses space or comma separated comparisons
+// using ^, ~, >, >=, <, <= or =.
+func parseSemverConstraint(s string) (semverConstraint, error) {
+	var c semverConstraint
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == ',' }) {
+		op := strings.TrimRight(f, "v0123456789.-+abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+		v, parts, ok := parseSemver(f[len(op):])
+		if !ok {
+			return nil, fmt.Errorf("invalid version constraint %v", s)
+		}
+		next := func(major, minor int) *semver {
+			return &semver{major: major, minor: minor}
+		}
+		var r semverRange
+		switch op {
+		case "^":
+			r.min = &v
+			switch {
+			case v.major > 0 || parts == 1:
+				r.max = next(v.major+1, 0)
+			case v.minor > 0 || parts == 2:
+				r.max = next(0, v.minor+1)
+			default:
+				r.max = &semver{patch: v.patch + 1}
+			}
+		case "~":
+			r.min = &v
+			if parts == 1 {
+				r.max = next(v.major+1, 0)
+			} else {
+				r.max = next(v.major, v.minor+1)
+			}
+		case ">":
+			r.min, r.minOpen = &v, true
+		case ">=":
+			r.min = &v
+		case "<":
+			r.max = &v
+		case "<=":
+			r.max, r.maxOpen = &v, true
+		case "=", "":
+			r.min, r.max, r.maxOpen = &v, &v, true
+		default:
+			return nil, fmt.Errorf("invalid version constraint %v", s)
+		}
+		c = append(c, r)
+	}
+	if len(c) == 0 {
+		return nil, fmt.Errorf("invalid version constraint %v", s)
+	}
+	return c, nil
+}
+
+// match checks if v satisfies the constraint. Pre-releases never match.
+func (c semverConstraint) match(v semver) bool {
+	if v.pre != "" {
+		return false
+	}
+	for _, r := range c {
+		if r.min != nil {
+			if cmp := v.compare(*r.min); cmp < 0 || (cmp == 0 && r.minOpen) {
+				return false
+			}
+		}
+		if r.max != nil {
+			if cmp := v.compare(*r.max); cmp > 0 || (cmp == 0 && !r.maxOpen) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// latest returns the highest of tags satisfying the constraint or
+// an empty string if none does.
+func (c semverConstraint) latest(tags []string) string {
+	var latest string
+	var max semver
+	for _, tag := range tags {
+		v, _, ok := parseSemver(tag)
+		if !ok || !c.match(v) {
+			continue
+		}
+		if latest == "" || v.compare(max) > 0 {
+			latest, max = tag, v
+		}
+	}
+	return latest
+}
@@ -0,0 +1,53 @@
+package git
+
+import "testing"
+
+func TestSemverConstraint(t *testing.T) {
+	for i, test := range []struct {
+		constraint string
+		version    string
+		match      bool
+	}{
+		{"^1.2", "v1.2.0", true},
+		{"^1.2", "v1.9.3", true},
+		{"^1.2", "v2.0.0", false},
+		{"^1.2", "v1.1.9", false},
+		{"^0.2.3", "0.2.9", true},
+		{"^0.2.3", "0.3.0", false},
+		{"^0.0.3", "0.0.4", false},
+		{"~1.2.3", "1.2.9", true},
+		{"~1.2.3", "1.3.0", false},
+		{"~1", "1.9.0", true},
+		{">=1.0 <2", "1.5.0", true},
+		{">=1.0 <2", "2.0.0", false},
+		{">=1.0, <=2", "2.0.0", true},
+		{">1.0", "1.0.0", false},
+		{"=1.2.3", "v1.2.3", true},
+		{"=1.2.3", "v1.2.4", false},
+		{"^1.2", "v1.3.0-beta.1", false},
+		{"^1.2", "release", false},
+	} {
+		c, err := parseSemverConstraint(test.constraint)
+		if err != nil {
+			t.Errorf("Test %v: Unexpected error %v", i, err)
+			continue
+		}
+		v, _, ok := parseSemver(test.version)
+		if match := ok && c.match(v); match != test.match {
+			t.Errorf("Test %v: Expected %v to match %v: %v", i, test.version, test.constraint, test.match)
+		}
+	}
+
+	for i, constraint := range []string{"^", "^a.b", "!1.2", "^1.2.3.4", ""} {
+		if _, err := parseSemverConstraint(constraint); err == nil {
+			t.Errorf("Test %v: Expected error for %v", i, constraint)
+		}
+	}
+
+	c, err := parseSemverConstraint("^1.2")
+	check(t, err)
+	tags := []string{"v1.1.0", "v1.10.0", "v1.9.0", "v2.0.0", "v1.11.0-rc.1", "latest"}
+	if latest := c.latest(tags); latest != "v1.10.0" {
+		t.Errorf("Expected latest tag v1.10.0, found %v", latest)
+	}
+}
@@ -1,6 +1,7 @@
 package git
 
 import (
+	"fmt"
 	"sync"
 
 	"github.com/abiosoft/caddy-git/gitos"
@@ -8,8 +9,8 @@ import (
 
 var (
 	// Services holds all git pulling services and provides the function to
-	// stop them.
-	Services = &services{}
+	// stop them. It is also the registry of repositories by id.
+	Services = &services{repos: make(map[string]*Repo)}
 )
 
 // repoService is the service that runs in background and periodically
@@ -18,6 +19,13 @@ type repoService struct {
 	repo   *Repo
 	ticker gitos.Ticker  // ticker to tick at intervals
 	halt   chan struct{} // channel to notify service to halt and stop pulling.
+	done   chan struct{} // closed when the service is halted.
+}
+
+// stop halts the service and waits until it is terminated.
+func (s *repoService) stop() {
+	close(s.halt)
+	<-s.done
 }
 
 // Start starts a new background service to pull periodically.
@@ -31,12 +39,14 @@ func Start(repo *Repo) {
 		repo,
 		gos.NewTicker(repo.Interval),
 		make(chan struct{}),
+		make(chan struct{}),
 	}
 	go func(s *repoService) {
+		defer close(s.done)
 		for {
 			select {
 			case <-s.ticker.C():
-				err := repo.Pull()
+				err := repo.PullFor(TriggerInterval)
 				if err != nil {
 					Logger().Println(err)
 				}
@@ -51,12 +61,81 @@ func Start(repo *Repo) {
 	Services.add(service)
 }
 
-// services stores all repoServices
+// services stores all repoServices and registered repositories.
 type services struct {
 	services []*repoService
+	repos    map[string]*Repo // registered repositories by id
 	sync.Mutex
 }
 
+// register registers repo by its id. It fails if another repository
+// with the same id is registered.
+func (s *services) register(repo *Repo) error {
+	s.Lock()
+	defer s.Unlock()
+
+	id := repo.ID()
+	if r, ok := s.repos[id]; ok && r != repo {
+		return fmt.Errorf("another repo with id '%v' exists. Use a different name", id)
+	}
+	s.repos[id] = repo
+	return nil
+}
+
+// unregister removes repo from the registry.
+func (s *services) unregister(repo *Repo) {
+	s.Lock()
+	defer s.Unlock()
+
+	id := repo.ID()
+	if s.repos[id] == repo {
+		delete(s.repos, id)
+	}
+}
+
+// Lookup returns the registered repository with id, or nil if
+// not found.
+func (s *services) Lookup(id string) *Repo {
+	s.Lock()
+	defer s.Unlock()
+	return s.repos[id]
+}
+
+// Pause pauses pulling the repository with id until resumed. A pull in
+// progress is not interrupted. It returns false if the repository is
+// not found.
+func (s *services) Pause(id string) bool {
+	repo := s.Lookup(id)
+	if repo == nil {
+		return false
+	}
+	repo.setPaused(true)
+	return true
+}
+
+// Resume resumes pulling the paused repository with id. It returns false
+// if the repository is not found.
+func (s *services) Resume(id string) bool {
+	repo := s.Lookup(id)
+	if repo == nil {
+		return false
+	}
+	repo.setPaused(false)
+	return true
+}
+
+// Halt stops the running services pulling the repository with id. It
+// waits until the services are terminated before returning and returns
+// false if the repository is not found.
+func (s *services) Halt(id string) bool {
+	repo := s.Lookup(id)
+	if repo == nil {
+		return false
+	}
+	s.stopRepo(repo)
+	return true
+}
+
 // add adds a new service to list of services.
 func (s *services) add(r *repoService) {
 	s.Lock()
@@ -68,23 +147,51 @@ func (s *services) add(r *repoService) {
 // Stop stops at most `limit` running services pulling from git repo at
 // repoURL. It waits until the service is terminated before returning.
 // If limit is less than zero, it is ignored.
-// TODO find better ways to identify repos
+// Halt is preferred as repos at the same URL can not be told apart.
 func (s *services) Stop(repoURL string, limit int) {
 	s.Lock()
-	defer s.Unlock()
+	var stopped []*repoService
 
 	// locate repos
 	for i, j := 0, 0; i < len(s.services) && ((limit >= 0 && j < limit) || limit < 0); i++ {
 		service := s.services[i]
 		if string(service.repo.URL) == repoURL {
-			// send halt signal
-			service.halt <- struct{}{}
+			stopped = append(stopped, service)
 			s.services[i] = nil
 			j++
 		}
 	}
+	s.compact()
+	s.Unlock()
+
+	// halt them outside the lock as they may be pulling
+	for _, service := range stopped {
+		service.stop()
+	}
+}
+
+// stopRepo stops all running services pulling repo. It waits until
+// the services are terminated before returning.
+func (s *services) stopRepo(repo *Repo) {
+	s.Lock()
+	var stopped []*repoService
+	for i, service := range s.services {
+		if service.repo == repo {
+			stopped = append(stopped, service)
+			s.services[i] = nil
+		}
+	}
+	s.compact()
+	s.Unlock()
+
+	for _, service := range stopped {
+		service.stop()
+	}
+}
 
-	// remove them from repos list
+// compact removes stopped services from the list of services.
+// It must be called with the lock held.
+func (s *services) compact() {
 	services := s.services[:0]
 	for _, s := range s.services {
 		if s != nil {
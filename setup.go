@@ -2,8 +2,10 @@ package git
 
 import (
 	"fmt"
+	"net/http"
 	"net/url"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
@@ -17,6 +19,9 @@ const (
 	// DefaultInterval is the minimum interval to delay before
 	// requesting another git pull
 	DefaultInterval time.Duration = time.Hour * 1
+
+	// MinInterval is the minimum interval between periodic pulls.
+	MinInterval time.Duration = time.Second * 5
 )
 
 func init() {
@@ -26,6 +31,21 @@ func init() {
 	})
 }
 
+// pullWorkersKey is the key of the pull_workers of the server in
+// the storage of the caddy instance.
+const pullWorkersKey = "git.pull_workers"
+
+// setPullWorkers sets the workers of the Scheduler to the pull_workers
+// of the server, or the default if not set, so a reload without
+// pull_workers does not keep the previous value.
+func setPullWorkers(c *caddy.Controller) {
+	workers, ok := c.Get(pullWorkersKey).(int)
+	if !ok {
+		workers = runtime.NumCPU()
+	}
+	Scheduler.SetWorkers(workers)
+}
+
 // setup configures a new Git service routine.
 func setup(c *caddy.Controller) error {
 	git, err := parse(c)
@@ -39,38 +59,72 @@ func setup(c *caddy.Controller) error {
 	// functions to execute at startup
 	var startupFuncs []func() error
 
+	// functions to execute on shutdown and to resume if a restart fails
+	var shutdownFuncs, resumeFuncs []func() error
+
 	// loop through all repos and and start monitoring
 	for i := range git {
 		repo := git.Repo(i)
 
 		// If a HookUrl is set, we switch to event based pulling.
 		// Install the url handler
-		if repo.Hook.URL != "" {
+		periodic := repo.Hook.URL == ""
+		if !periodic {
 
 			hookRepos = append(hookRepos, repo)
+			if repo.Hook.Secret == "" {
+				Logger().Printf("Warning: webhook %v for %v has no secret, anyone who can reach it can trigger a pull.\n", repo.Hook.URL, repo.URL)
+			}
 
 			startupFuncs = append(startupFuncs, func() error {
-				return repo.Pull()
+				if err := Services.register(repo); err != nil {
+					return err
+				}
+
+				// load published ip ranges before the first webhook
+				for _, name := range hookIPSources(repo.Hook) {
+					ipSources[name].ranges()
+				}
+				return repo.PullFor(TriggerStartup)
 			})
 
 		} else {
 			startupFuncs = append(startupFuncs, func() error {
+				if err := Services.register(repo); err != nil {
+					return err
+				}
 
 				// Start service routine in background
 				Start(repo)
 
 				// Do a pull right away to return error
-				return repo.Pull()
+				return repo.PullFor(TriggerStartup)
 			})
 		}
+
+		// stop pulling and running commands before a reload starts the
+		// new instance, and resume if the reload fails.
+		shutdownFuncs = append(shutdownFuncs, repo.shutdown)
+		resumeFuncs = append(resumeFuncs, func() error {
+			return repo.resume(periodic)
+		})
 	}
 
 	// ensure the functions are executed once per server block
 	// for cases like server1.com, server2.com { ... }
 	c.OncePerServerBlock(func() error {
+		c.OnStartup(func() error {
+			setPullWorkers(c)
+			return nil
+		})
 		for i := range startupFuncs {
 			c.OnStartup(startupFuncs[i])
 		}
+		for i := range shutdownFuncs {
+			c.OnRestart(shutdownFuncs[i])
+			c.OnRestartFailed(resumeFuncs[i])
+			c.OnShutdown(shutdownFuncs[i])
+		}
 		return nil
 	})
 
@@ -93,6 +147,7 @@ func parse(c *caddy.Controller) (Git, error) {
 	config := httpserver.GetConfig(c)
 	for c.Next() {
 		repo := &Repo{Branch: "master", Interval: DefaultInterval, Path: config.Root}
+		requireAuth := ""
 
 		args := c.RemainingArgs()
 
@@ -113,6 +168,11 @@ func parse(c *caddy.Controller) (Git, error) {
 
 		for c.NextBlock() {
 			switch c.Val() {
+			case "name":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				repo.Name = c.Val()
 			case "repo":
 				if !c.NextArg() {
 					return nil, c.ArgErr()
@@ -128,6 +188,14 @@ func parse(c *caddy.Controller) (Git, error) {
 					return nil, c.ArgErr()
 				}
 				repo.Branch = c.Val()
+
+				// version constraints may have multiple comparisons
+				if isSemverConstraint(repo.Branch) {
+					repo.Branch = strings.Join(append([]string{repo.Branch}, c.RemainingArgs()...), " ")
+					if _, err := parseSemverConstraint(repo.Branch); err != nil {
+						return nil, c.Err(err.Error())
+					}
+				}
 			case "key":
 				if !c.NextArg() {
 					return nil, c.ArgErr()
@@ -141,6 +209,9 @@ func parse(c *caddy.Controller) (Git, error) {
 				if t > 0 {
 					repo.Interval = time.Duration(t) * time.Second
 				}
+				if repo.Interval < MinInterval {
+					repo.Interval = MinInterval
+				}
 			case "args", "clone_args":
 				repo.CloneArgs = c.RemainingArgs()
 			case "pull_args":
@@ -164,20 +235,133 @@ func parse(c *caddy.Controller) (Git, error) {
 					return nil, c.Errf("invalid hook type %v", t)
 				}
 				repo.Hook.Type = t
+			case "pull_workers":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				n, err := strconv.Atoi(c.Val())
+				if err != nil || n < 1 {
+					return nil, c.Errf("invalid number of pull workers %v", c.Val())
+				}
+				// the workers are shared by all repositories
+				if workers, ok := c.Get(pullWorkersKey).(int); ok && workers != n {
+					return nil, c.Errf("pull_workers %v conflicts with pull_workers %v set before", n, workers)
+				}
+				c.Set(pullWorkersKey, n)
+			case "hook_require_auth":
+				requireAuth = "on"
+				if c.NextArg() {
+					requireAuth = c.Val()
+				}
+				if requireAuth != "on" && requireAuth != "off" {
+					return nil, c.Errf("invalid hook_require_auth value %v, expected on or off", requireAuth)
+				}
+			case "hook_ref_path":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				repo.Hook.Generic.RefPath = c.Val()
+			case "hook_auth":
+				args := c.RemainingArgs()
+				switch {
+				case len(args) == 1 && args[0] == "bearer":
+				case len(args) == 2 && args[0] == "basic":
+					repo.Hook.Generic.User = args[1]
+				default:
+					return nil, c.Err("hook_auth expects bearer or basic user")
+				}
+				repo.Hook.Generic.Auth = args[0]
+			case "hook_hmac":
+				args := c.RemainingArgs()
+				if len(args) == 0 || len(args) > 3 {
+					return nil, c.ArgErr()
+				}
+				repo.Hook.Generic.HMACHeader = args[0]
+				if len(args) > 1 {
+					if _, ok := hmacHashes[args[1]]; !ok {
+						return nil, c.Errf("invalid hook_hmac algorithm %v", args[1])
+					}
+					repo.Hook.Generic.HMACHash = args[1]
+				}
+				if len(args) > 2 {
+					if _, ok := hmacEncodings[args[2]]; !ok {
+						return nil, c.Errf("invalid hook_hmac encoding %v", args[2])
+					}
+					repo.Hook.Generic.HMACEncoding = args[2]
+				}
+			case "require_sha256":
+				repo.Hook.RequireSHA256 = true
+			case "hook_allow_ips":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return nil, c.ArgErr()
+				}
+				for _, arg := range args {
+					if err := repo.Hook.AllowIPs.add(arg); err != nil {
+						return nil, c.Err(err.Error())
+					}
+				}
+			case "hook_allow_repos":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return nil, c.ArgErr()
+				}
+				repo.Hook.AllowRepos = append(repo.Hook.AllowRepos, args...)
+			case "hook_mode":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				switch c.Val() {
+				case "sync":
+					repo.Hook.Async = false
+				case "async":
+					repo.Hook.Async = true
+				default:
+					return nil, c.Errf("invalid hook mode %v", c.Val())
+				}
+			case "before":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				command := c.Val()
+				args := c.RemainingArgs()
+				repo.Before = append(repo.Before, NewThen(command, args...))
+			case "rollback_on_failure":
+				repo.Rollback = true
+			case "health_check":
+				if !c.NextArg() {
+					return nil, c.ArgErr()
+				}
+				repo.Health = HealthCheck{URL: c.Val(), Status: http.StatusOK}
+				if c.NextArg() {
+					status, err := strconv.Atoi(c.Val())
+					if err != nil {
+						return nil, c.Errf("invalid health check status %v", c.Val())
+					}
+					repo.Health.Status = status
+				}
 			case "then":
 				if !c.NextArg() {
 					return nil, c.ArgErr()
 				}
 				command := c.Val()
 				args := c.RemainingArgs()
-				repo.Then = append(repo.Then, NewThen(command, args...))
+				then := newCmd(command, args...)
+				if err := parseBlock(c, then.parseOption); err != nil {
+					return nil, err
+				}
+				repo.Then = append(repo.Then, then)
 			case "then_long":
 				if !c.NextArg() {
 					return nil, c.ArgErr()
 				}
 				command := c.Val()
 				args := c.RemainingArgs()
-				repo.Then = append(repo.Then, NewLongThen(command, args...))
+				then := newLongCmd(command, args...)
+				if err := parseBlock(c, then.parseOption); err != nil {
+					return nil, err
+				}
+				repo.Then = append(repo.Then, then)
 			default:
 				return nil, c.ArgErr()
 			}
@@ -187,6 +371,21 @@ func parse(c *caddy.Controller) (Git, error) {
 		if repo.URL == "" {
 			return nil, c.ArgErr()
 		}
+
+		// authentication is required by default if a secret is set
+		switch requireAuth {
+		case "":
+			repo.Hook.RequireAuth = repo.Hook.Secret != ""
+		case "on":
+			if repo.Hook.Secret == "" {
+				return nil, c.Err("hook_require_auth requires a hook secret")
+			}
+			repo.Hook.RequireAuth = true
+		}
+		if repo.Hook.RequireSHA256 && repo.Hook.Secret == "" {
+			return nil, c.Err("require_sha256 requires a hook secret")
+		}
+
 		// validate repo url
 		if repoURL, err := parseURL(string(repo.URL), repo.KeyPath != ""); err != nil {
 			return nil, err
@@ -216,6 +415,14 @@ func parse(c *caddy.Controller) (Git, error) {
 			return nil, err
 		}
 
+		// keep the id stable if the branch changes later on
+		repo.id = repo.ID()
+		for _, r := range git {
+			if r.ID() == repo.ID() {
+				return nil, c.Errf("another repo with id '%v' exists. Use a different name", repo.ID())
+			}
+		}
+
 		git = append(git, repo)
 
 	}
@@ -223,6 +430,207 @@ func parse(c *caddy.Controller) (Git, error) {
 	return git, nil
 }
 
+// parseBlock parses the optional block of options following a
+// subdirective. Each option is passed to fn with its arguments.
+func parseBlock(c *caddy.Controller, fn func(c *caddy.Controller, option string, args []string) error) error {
+	// block must open on the same line
+	if !c.NextArg() {
+		return nil
+	}
+	if c.Val() != "{" {
+		return c.ArgErr()
+	}
+	for c.Next() {
+		if c.Val() == "}" {
+			return nil
+		}
+		if err := fn(c, c.Val(), c.RemainingArgs()); err != nil {
+			return err
+		}
+	}
+	return c.EOFErr()
+}
+
+// parseDuration parses s as a number of seconds or a duration string
+// e.g. 10 or 1m30s.
+func parseDuration(s string) (time.Duration, error) {
+	if t, err := strconv.Atoi(s); err == nil {
+		return time.Duration(t) * time.Second, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// parseOption parses an option in the block of a then or then_long
+// command.
+func (g *gitCmd) parseOption(c *caddy.Controller, option string, args []string) error {
+	switch option {
+	case "log":
+		if len(args) < 1 || len(args) > 3 {
+			return c.ArgErr()
+		}
+		g.log = &rotatingFile{path: args[0], maxSize: defaultLogSize << 20, backups: defaultLogBackups}
+		if len(args) > 1 {
+			size, err := strconv.Atoi(args[1])
+			if err != nil || size <= 0 {
+				return c.Errf("invalid log size %v", args[1])
+			}
+			g.log.maxSize = int64(size) << 20
+		}
+		if len(args) > 2 {
+			backups, err := strconv.Atoi(args[2])
+			if err != nil || backups < 0 {
+				return c.Errf("invalid log backups %v", args[2])
+			}
+			g.log.backups = backups
+		}
+		return nil
+	case "log_tail":
+		if len(args) != 1 {
+			return c.ArgErr()
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			return c.Errf("invalid log tail %v", args[0])
+		}
+		g.tailLines = n
+		return nil
+	}
+
+	if !g.background {
+		return c.Errf("%v is only supported by then_long", option)
+	}
+	switch option {
+	case "stop_signal":
+		if len(args) != 1 {
+			return c.ArgErr()
+		}
+		sig, ok := lookupSignal(args[0])
+		if !ok {
+			return c.Errf("invalid stop signal %v", args[0])
+		}
+		g.stopSignal = sig
+	case "stop_timeout":
+		if len(args) != 1 {
+			return c.ArgErr()
+		}
+		d, err := parseDuration(args[0])
+		if err != nil || d < 0 {
+			return c.Errf("invalid stop timeout %v", args[0])
+		}
+		g.stopTimeout = d
+	case "restart":
+		if len(args) != 1 {
+			return c.ArgErr()
+		}
+		switch args[0] {
+		case restartAlways, restartOnFailure, restartNever:
+			g.restart.mode = args[0]
+		default:
+			return c.Errf("invalid restart policy %v", args[0])
+		}
+	case "restart_delay":
+		if len(args) != 1 && len(args) != 2 {
+			return c.ArgErr()
+		}
+		d, err := parseDuration(args[0])
+		if err != nil || d <= 0 {
+			return c.Errf("invalid restart delay %v", args[0])
+		}
+		g.restart.delay = d
+		if len(args) == 2 {
+			max, err := parseDuration(args[1])
+			if err != nil || max < d {
+				return c.Errf("invalid maximum restart delay %v", args[1])
+			}
+			g.restart.maxDelay = max
+		} else if g.restart.maxDelay < d {
+			g.restart.maxDelay = d
+		}
+	case "restart_limit":
+		if len(args) != 2 {
+			return c.ArgErr()
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			return c.Errf("invalid restart limit %v", args[0])
+		}
+		d, err := parseDuration(args[1])
+		if err != nil || d <= 0 {
+			return c.Errf("invalid restart limit window %v", args[1])
+		}
+		g.restart.limit, g.restart.window = n, d
+	case "restart_reset":
+		if len(args) != 1 {
+			return c.ArgErr()
+		}
+		d, err := parseDuration(args[0])
+		if err != nil || d <= 0 {
+			return c.Errf("invalid restart reset %v", args[0])
+		}
+		g.restart.reset = d
+	case "ready":
+		if len(args) < 2 {
+			return c.ArgErr()
+		}
+		probe := &readyProbe{kind: args[0], timeout: defaultReadyTimeout}
+		switch probe.kind {
+		case "tcp":
+			if len(args) != 2 {
+				return c.ArgErr()
+			}
+			probe.address = args[1]
+		case "http":
+			if len(args) > 3 {
+				return c.ArgErr()
+			}
+			probe.address, probe.status = args[1], http.StatusOK
+			if len(args) == 3 {
+				status, err := strconv.Atoi(args[2])
+				if err != nil {
+					return c.Errf("invalid ready status %v", args[2])
+				}
+				probe.status = status
+			}
+		case "log":
+			pattern, err := regexp.Compile(strings.Join(args[1:], " "))
+			if err != nil {
+				return c.Errf("invalid ready log pattern. Error: %v", err)
+			}
+			probe.pattern = pattern
+		default:
+			return c.Errf("invalid ready probe %v", probe.kind)
+		}
+		g.ready = probe
+	case "ready_timeout":
+		if len(args) != 1 {
+			return c.ArgErr()
+		}
+		if g.ready == nil {
+			return c.Err("ready_timeout requires ready")
+		}
+		d, err := parseDuration(args[0])
+		if err != nil || d <= 0 {
+			return c.Errf("invalid ready timeout %v", args[0])
+		}
+		g.ready.timeout = d
+	case "blue_green":
+		if len(args) != 3 {
+			return c.ArgErr()
+		}
+		if args[1] == args[2] {
+			return c.Errf("blue_green requires different addresses, found %v twice", args[1])
+		}
+		g.blueGreen = &blueGreen{
+			name:      args[0],
+			addresses: [2]string{args[1], args[2]},
+			active:    -1,
+		}
+	default:
+		return c.ArgErr()
+	}
+	return nil
+}
+
 // parseURL validates if repoUrl is a valid git url.
 func parseURL(repoURL string, private bool) (*url.URL, error) {
 	// scheme
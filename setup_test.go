@@ -3,6 +3,8 @@ package git
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
@@ -22,6 +24,32 @@ func TestGitSetup(t *testing.T) {
 	check(t, err)
 }
 
+func TestPullWorkers(t *testing.T) {
+	defer Scheduler.SetWorkers(Scheduler.Workers())
+
+	c := caddy.NewTestController("http", `git https://github.com/user/repo {
+		pull_workers 3
+	}`)
+	_, err := parse(c)
+	check(t, err)
+	if workers, _ := c.Get(pullWorkersKey).(int); workers != 3 {
+		t.Errorf("Expected pull_workers 3, found %v", workers)
+	}
+	setPullWorkers(c)
+	if workers := Scheduler.Workers(); workers != 3 {
+		t.Errorf("Expected 3 workers, found %v", workers)
+	}
+
+	// reload without pull_workers restores the default
+	c = caddy.NewTestController("http", `git https://github.com/user/repo`)
+	_, err = parse(c)
+	check(t, err)
+	setPullWorkers(c)
+	if workers := Scheduler.Workers(); workers != runtime.NumCPU() {
+		t.Errorf("Expected %v workers, found %v", runtime.NumCPU(), workers)
+	}
+}
+
 func TestGitParse(t *testing.T) {
 	tests := []struct {
 		input     string
@@ -69,6 +97,263 @@ func TestGitParse(t *testing.T) {
 			URL:     "ssh://git@github.com:user/repo",
 			Then:    []Then{NewThen("echo", "hello world")},
 		}},
+		{`git https://github.com/user/repo {
+		before ./drain.sh
+		before pg_dump db
+		then echo hello world
+		}`, false, &Repo{
+			URL:    "https://github.com/user/repo",
+			Before: []Then{NewThen("./drain.sh"), NewThen("pg_dump", "db")},
+			Then:   []Then{NewThen("echo", "hello world")},
+		}},
+		{`git https://github.com/user/repo {
+		rollback_on_failure
+		health_check http://localhost:8080/healthz 204
+		}`, false, &Repo{
+			URL:      "https://github.com/user/repo",
+			Rollback: true,
+			Health:   HealthCheck{URL: "http://localhost:8080/healthz", Status: 204},
+		}},
+		{`git https://github.com/user/repo {
+		health_check http://localhost:8080/healthz ok
+		}`, true, nil},
+		{`git https://github.com/user/repo {
+		then_long npm start {
+			stop_signal SIGINT
+			stop_timeout 30
+		}
+		then echo hello world
+		}`, false, &Repo{
+			URL:  "https://github.com/user/repo",
+			Then: []Then{NewLongThen("npm", "start"), NewThen("echo", "hello world")},
+		}},
+		{`git https://github.com/user/repo {
+		then_long npm start {
+			stop_signal SIGFOO
+		}
+		}`, true, nil},
+		{`git https://github.com/user/repo {
+		then_long npm start {
+			restart always
+			restart_delay 1s 30s
+			restart_limit 10 5m
+			restart_reset 1m
+		}
+		}`, false, &Repo{
+			URL:  "https://github.com/user/repo",
+			Then: []Then{NewLongThen("npm", "start")},
+		}},
+		{`git https://github.com/user/repo {
+		then_long npm start {
+			restart sometimes
+		}
+		}`, true, nil},
+		{`git https://github.com/user/repo {
+		then_long npm start {
+			ready http http://localhost:8080/healthz 204
+			ready_timeout 1m
+		}
+		then_long ./server {
+			ready log ^listening on .*
+		}
+		then_long ./worker {
+			ready tcp localhost:9000
+		}
+		}`, false, &Repo{
+			URL:  "https://github.com/user/repo",
+			Then: []Then{NewLongThen("npm", "start"), NewLongThen("./server"), NewLongThen("./worker")},
+		}},
+		{`git https://github.com/user/repo {
+		then_long npm start {
+			ready_timeout 1m
+		}
+		}`, true, nil},
+		{`git https://github.com/user/repo {
+		then_long ./server --port {port} {
+			blue_green backend localhost:8081 localhost:8082
+			ready http http://{upstream}/healthz
+		}
+		}`, false, &Repo{
+			URL:  "https://github.com/user/repo",
+			Then: []Then{NewLongThen("./server", "--port", "{port}")},
+		}},
+		{`git https://github.com/user/repo {
+		then_long ./server --port {port} {
+			blue_green backend localhost:8081 localhost:8081
+		}
+		}`, true, nil},
+		{`git https://github.com/user/repo {
+		then hugo {
+			log /var/log/hugo.log
+			log_tail 50
+		}
+		then_long ./server {
+			log /var/log/server.log 100 5
+		}
+		}`, false, &Repo{
+			URL:  "https://github.com/user/repo",
+			Then: []Then{NewThen("hugo"), NewLongThen("./server")},
+		}},
+		{`git https://github.com/user/repo {
+		then hugo {
+			restart always
+		}
+		}`, true, nil},
+		{`git https://github.com/user/repo {
+		then hugo {
+			log /var/log/hugo.log big
+		}
+		}`, true, nil},
+		{`git https://github.com/user/repo {
+		then_long npm start {
+			ready udp localhost:9000
+		}
+		}`, true, nil},
+		{`git https://github.com/user/repo {
+		then_long npm start {
+			restart_delay 10s 1s
+		}
+		}`, true, nil},
+		{`git https://github.com/user/repo {
+		then_long npm start {
+			stop_timeout
+		}
+		}`, true, nil},
+		{`git https://github.com/user/repo {
+		name site
+		}`, false, &Repo{
+			Name: "site",
+			URL:  "https://github.com/user/repo",
+		}},
+		{`git https://github.com/user/repo {
+		name
+		}`, true, nil},
+		{`git https://github.com/user/repo
+		git https://github.com/user/repo`, true, nil},
+		{`git https://github.com/user/repo {
+		hook /webhook
+		hook_mode async
+		}`, false, &Repo{
+			URL:  "https://github.com/user/repo",
+			Hook: HookConfig{URL: "/webhook", Async: true},
+		}},
+		{`git https://github.com/user/repo {
+		hook /webhook
+		hook_mode later
+		}`, true, nil},
+		{`git https://github.com/user/repo {
+		pull_workers 4
+		}`, false, &Repo{
+			URL: "https://github.com/user/repo",
+		}},
+		{`git https://github.com/user/repo {
+		pull_workers 0
+		}`, true, nil},
+		{`git https://github.com/user/repo {
+		pull_workers 4
+		}
+		git https://github.com/user/other {
+		pull_workers 4
+		}`, false, &Repo{
+			URL: "https://github.com/user/repo",
+		}},
+		{`git https://github.com/user/repo {
+		pull_workers 4
+		}
+		git https://github.com/user/other {
+		pull_workers 2
+		}`, true, nil},
+		{`git https://github.com/user/repo {
+		hook /webhook secret
+		require_sha256
+		}`, false, &Repo{
+			URL:  "https://github.com/user/repo",
+			Hook: HookConfig{URL: "/webhook", Secret: "secret", RequireSHA256: true, RequireAuth: true},
+		}},
+		{`git https://github.com/user/repo {
+		hook /webhook
+		require_sha256
+		}`, true, nil},
+		{`git https://github.com/user/repo {
+		hook /webhook
+		hook_allow_repos user/fork
+		hook_allow_repos github.com/user/other org/repo
+		}`, false, &Repo{
+			URL:  "https://github.com/user/repo",
+			Hook: HookConfig{URL: "/webhook", AllowRepos: []string{"user/fork", "github.com/user/other", "org/repo"}},
+		}},
+		{`git https://github.com/user/repo {
+		hook /webhook
+		hook_allow_repos
+		}`, true, nil},
+		{`git https://github.com/user/repo {
+		hook /webhook
+		hook_allow_ips 10.0.0.0/8 github
+		hook_allow_ips 192.168.1.1
+		}`, false, &Repo{
+			URL: "https://github.com/user/repo",
+			Hook: HookConfig{URL: "/webhook", AllowIPs: IPAllowlist{
+				Nets: []net.IPNet{
+					{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(8, 32)},
+					{IP: net.IP{192, 168, 1, 1}, Mask: net.CIDRMask(32, 32)},
+				},
+				Sources: []string{"github"},
+			}},
+		}},
+		{`git https://github.com/user/repo {
+		hook /webhook
+		hook_allow_ips 10.0.0.0/33
+		}`, true, nil},
+		{`git https://github.com/user/repo {
+		hook /webhook secret
+		hook_require_auth off
+		}`, false, &Repo{
+			URL:  "https://github.com/user/repo",
+			Hook: HookConfig{URL: "/webhook", Secret: "secret"},
+		}},
+		{`git https://github.com/user/repo {
+		hook /webhook
+		hook_require_auth
+		}`, true, nil},
+		{`git https://github.com/user/repo {
+		hook /webhook secret
+		hook_require_auth maybe
+		}`, true, nil},
+		{`git https://github.com/user/repo {
+		hook /webhook secret
+		hook_type generic
+		hook_ref_path ?branch
+		hook_auth basic ci
+		hook_hmac X-Signature sha512 base64
+		}`, false, &Repo{
+			URL: "https://github.com/user/repo",
+			Hook: HookConfig{URL: "/webhook", Secret: "secret", Type: "generic", RequireAuth: true, Generic: GenericConfig{
+				RefPath:      "?branch",
+				Auth:         "basic",
+				User:         "ci",
+				HMACHeader:   "X-Signature",
+				HMACHash:     "sha512",
+				HMACEncoding: "base64",
+			}},
+		}},
+		{`git https://github.com/user/repo {
+		hook_auth basic
+		}`, true, nil},
+		{`git https://github.com/user/repo {
+		hook_hmac X-Signature md5
+		}`, true, nil},
+		{`git https://github.com/user/repo {
+		hook_hmac X-Signature sha1 base32
+		}`, true, nil},
+		{`git https://github.com/user/repo {
+		branch >=1.2 <2
+		}`, false, &Repo{
+			URL:    "https://github.com/user/repo",
+			Branch: ">=1.2 <2",
+		}},
+		{`git https://github.com/user/repo {
+		branch ^a.b
+		}`, true, nil},
 		{`git https://user@bitbucket.org/user/repo.git`, false, &Repo{
 			URL: "https://user@bitbucket.org/user/repo.git",
 		}},
@@ -120,9 +405,10 @@ func TestGitParse(t *testing.T) {
 			KeyPath: "~/.key",
 			URL:     "ssh://git@bitbucket.org:2222/user/repo.git",
 			Hook: HookConfig{
-				URL:    "/webhook",
-				Secret: "some-secrets",
-				Type:   "gogs",
+				URL:         "/webhook",
+				Secret:      "some-secrets",
+				Type:        "gogs",
+				RequireAuth: true,
 			},
 		}},
 	}
@@ -219,6 +505,9 @@ func reposEqual(expected, repo *Repo) bool {
 	if expected == nil {
 		return repo == nil
 	}
+	if expected.Name != "" && expected.Name != repo.Name {
+		return false
+	}
 	if expected.Branch != "" && expected.Branch != repo.Branch {
 		return false
 	}
@@ -234,12 +523,21 @@ func reposEqual(expected, repo *Repo) bool {
 	if expected.Path != "" && expected.Path != repo.Path {
 		return false
 	}
+	if expected.Before != nil && thenStr(expected.Before) != thenStr(repo.Before) {
+		return false
+	}
 	if expected.Then != nil && thenStr(expected.Then) != thenStr(repo.Then) {
 		return false
 	}
 	if expected.URL != "" && expected.URL != repo.URL {
 		return false
 	}
+	if expected.Rollback != repo.Rollback {
+		return false
+	}
+	if expected.Health.URL != "" && expected.Health != repo.Health {
+		return false
+	}
 	if fmt.Sprint(expected.Hook) != fmt.Sprint(repo.Hook) {
 		return false
 	}
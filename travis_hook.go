@@ -1,6 +1,7 @@
 package git
 
 import (
+	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
@@ -24,7 +25,7 @@ func (t TravisHook) Handle(w http.ResponseWriter, r *http.Request, repo *Repo) (
 		return http.StatusMethodNotAllowed, errors.New("the request had an invalid method")
 	}
 	if err := t.handleSignature(r, repo.Hook.Secret); err != nil {
-		return http.StatusBadRequest, err
+		return http.StatusUnauthorized, err
 	}
 	if err := r.ParseForm(); err != nil {
 		return http.StatusBadRequest, err
@@ -38,25 +39,23 @@ func (t TravisHook) Handle(w http.ResponseWriter, r *http.Request, repo *Repo) (
 		return http.StatusBadRequest, err
 	}
 
+	if err := hookCheckRepo(t, repo, data.hookRepo()); err != nil {
+		return http.StatusForbidden, err
+	}
+
 	// ignored webhooks
-	err := hookIgnoredError{hookType: hookName(t)}
 	if data.Type != "push" || data.StatusMessage != "Passed" {
-		err.err = fmt.Errorf("Ignoring payload with wrong status or type")
-		return 200, err
-	}
-	if repo.Branch != "" && data.Branch != repo.Branch {
-		err.err = fmt.Errorf("Ignoring push for branch %s", data.Branch)
-		return 200, err
+		return 200, hookIgnoredError{hookType: hookName(t), err: fmt.Errorf("Ignoring payload with wrong status or type")}
 	}
 
-	// attempt pull
-	if err := repo.Pull(); err != nil {
-		return http.StatusInternalServerError, err
-	}
-	if err := repo.checkoutCommit(data.Commit); err != nil {
-		return http.StatusInternalServerError, err
+	// check out the commit that passed, the branch may have moved on
+	err := hookDispatchThen(t, repo, func() error {
+		return repo.checkoutCommit(data.Commit)
+	}, data.event())
+	if !hookIgnored(err) && err != nil {
+		return http.StatusBadRequest, err
 	}
-	return 200, nil
+	return http.StatusOK, err
 }
 
 type travisPayload struct {
@@ -73,7 +72,43 @@ type travisPayload struct {
 	Branch        string    `json:"branch"`
 	Type          string    `json:"type"`
 	State         string    `json:"state"`
+	Tag           string    `json:"tag"`
 	Commit        string    `json:"commit"`
+	Message       string    `json:"message"`
+	AuthorName    string    `json:"author_name"`
+	CommitterName string    `json:"committer_name"`
+	Repository    struct {
+		Name      string `json:"name"`
+		OwnerName string `json:"owner_name"`
+		URL       string `json:"url"`
+	} `json:"repository"`
+}
+
+// hookRepo returns the repository of the payload.
+func (p travisPayload) hookRepo() HookRepo {
+	var h HookRepo
+	if p.Repository.Name != "" && p.Repository.OwnerName != "" {
+		h.Name = p.Repository.OwnerName + "/" + p.Repository.Name
+	}
+	h.URLs = nonEmpty(p.Repository.URL)
+	return h
+}
+
+// event returns the hook event of the payload. Builds of tags
+// report the tag name as branch too.
+func (p travisPayload) event() HookEvent {
+	ref := "refs/heads/" + p.Branch
+	if p.Tag != "" {
+		ref = "refs/tags/" + p.Tag
+	}
+	e := newHookEvent(ref)
+	e.After = p.Commit
+	e.Repo = p.hookRepo()
+	e.Pusher = p.CommitterName
+	if p.Commit != "" {
+		e.Commits = []HookCommit{{ID: p.Commit, Message: p.Message, Author: p.AuthorName}}
+	}
+	return e
 }
 
 // Check for an authorization signature in the request. Reject if not present. If validation required, check the sha
@@ -90,8 +125,7 @@ func (t TravisHook) handleSignature(r *http.Request, secret string) error {
 	content := r.Header.Get("Travis-Repo-Slug") + secret
 	hash := sha256.Sum256([]byte(content))
 	expectedMac := hex.EncodeToString(hash[:])
-	if signature != expectedMac {
-		fmt.Println(signature, expectedMac)
+	if !hmac.Equal([]byte(signature), []byte(expectedMac)) {
 		return errors.New("Invalid authorization header")
 	}
 	return nil
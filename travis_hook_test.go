@@ -0,0 +1,92 @@
+package git
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestTravisDeploy(t *testing.T) {
+	tHook := TravisHook{}
+	sum := sha256.Sum256([]byte("user/repo" + "secret"))
+	signature := hex.EncodeToString(sum[:])
+
+	for i, test := range []struct {
+		branch  string
+		payload string
+		code    int
+		ignored bool
+		tag     string
+	}{
+		{"master", "", 400, false, ""},
+		{"master", travisPayloadMaster, 200, false, ""},
+		{"master", travisPayloadFailed, 200, true, ""},
+		{"develop", travisPayloadMaster, 200, true, ""},
+		{"master", travisPayloadOther, 403, false, ""},
+		{"master", travisPayloadTag, 200, true, ""},
+		{"{latest}", travisPayloadMaster, 200, true, ""},
+		{"{latest}", travisPayloadTag, 200, false, "v1.2.0"},
+		{"^2.0.0", travisPayloadTag, 200, true, ""},
+	} {
+		repo := &Repo{URL: "https://github.com/user/repo", Branch: test.branch, Hook: HookConfig{URL: "/travis_deploy", Secret: "secret"}, stopped: true}
+		form := url.Values{}
+		if test.payload != "" {
+			form.Set("payload", test.payload)
+		}
+		req, err := http.NewRequest("POST", "/travis_deploy", strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatalf("Test %v: Could not create HTTP request: %v", i, err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Travis-Repo-Slug", "user/repo")
+		req.Header.Set("Authorization", signature)
+
+		code, err := tHook.Handle(httptest.NewRecorder(), req, repo)
+		if code != test.code {
+			t.Errorf("Test %v: Expected response code to be %v but was %v: %v", i, test.code, code, err)
+		}
+		if hookIgnored(err) != test.ignored {
+			t.Errorf("Test %v: Expected ignored to be %v but found %v", i, test.ignored, err)
+		}
+		if tag := repo.flight.takeTag(); tag != test.tag {
+			t.Errorf("Test %v: Expected tag %v but found %v", i, test.tag, tag)
+		}
+	}
+}
+
+var travisPayloadMaster = `{
+	"type": "push",
+	"status_message": "Passed",
+	"branch": "master",
+	"commit": "62aae5f70ceee39123ef",
+	"repository": {"name": "repo", "owner_name": "user", "url": "https://github.com/user/repo"}
+}`
+
+var travisPayloadFailed = `{
+	"type": "push",
+	"status_message": "Failed",
+	"branch": "master",
+	"commit": "62aae5f70ceee39123ef",
+	"repository": {"name": "repo", "owner_name": "user", "url": "https://github.com/user/repo"}
+}`
+
+var travisPayloadOther = `{
+	"type": "push",
+	"status_message": "Passed",
+	"branch": "master",
+	"commit": "62aae5f70ceee39123ef",
+	"repository": {"name": "other", "owner_name": "user", "url": "https://github.com/user/other"}
+}`
+
+var travisPayloadTag = `{
+	"type": "push",
+	"status_message": "Passed",
+	"branch": "v1.2.0",
+	"tag": "v1.2.0",
+	"commit": "62aae5f70ceee39123ef",
+	"repository": {"name": "repo", "owner_name": "user", "url": "https://github.com/user/repo"}
+}`
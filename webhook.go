@@ -1,9 +1,17 @@
 package git
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
+	"io/ioutil"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/caddyserver/caddy/caddyhttp/httpserver"
 )
@@ -16,11 +24,21 @@ type WebHook struct {
 
 // HookConfig is a webhook handler configuration.
 type HookConfig struct {
-	URL    string // url to listen on for webhooks
-	Secret string // secret to validate hooks
-	Type   string // type of Webhook
+	URL           string      // url to listen on for webhooks
+	Secret        string      // secret to validate hooks
+	Type          string      // type of Webhook
+	Async         bool        // pull in background and respond with a job
+	RequireSHA256 bool        // reject GitHub deliveries without SHA-256 signature
+	RequireAuth   bool        // reject unauthenticated hooks
+	AllowRepos    []string    // names or urls of other repositories to accept hooks of
+	AllowIPs      IPAllowlist // addresses allowed to send hooks, all if empty
+
+	Generic GenericConfig // configuration of the generic webhook
 }
 
+// jobsPath is the path under the webhook url serving hook jobs.
+const jobsPath = "/jobs/"
+
 // hookIgnoredError is returned when a webhook is ignored by the
 // webhook handler.
 type hookIgnoredError struct {
@@ -39,6 +57,353 @@ func hookIgnored(err error) bool {
 	return ok
 }
 
+// hookPullError is returned when the pull for a webhook failed.
+type hookPullError struct {
+	err error
+}
+
+// Error satisfies error interface
+func (h hookPullError) Error() string {
+	return fmt.Sprintf("webhook pull failed. Error: %v", h.err)
+}
+
+// hookPullFailed checks if err is of type hookPullError.
+func hookPullFailed(err error) bool {
+	_, ok := err.(hookPullError)
+	return ok
+}
+
+// hookRejectedError is returned when a webhook is about
+// a different repository.
+type hookRejectedError struct {
+	hookType string
+	repo     HookRepo
+}
+
+// Error satisfies error interface
+func (h hookRejectedError) Error() string {
+	name := h.repo.Name
+	if name == "" {
+		name = h.repo.URLs[0]
+	}
+	return fmt.Sprintf("%s webhook rejected. Found different repository %v", h.hookType, name)
+}
+
+// hookRejected checks if err is of type hookRejectedError.
+func hookRejected(err error) bool {
+	_, ok := err.(hookRejectedError)
+	return ok
+}
+
+// checkHMAC checks that signature is the hex encoded HMAC of body
+// using hash and secret.
+func checkHMAC(signature string, hash func() hash.Hash, secret string, body []byte) error {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return errors.New("could not verify request signature. The signature is malformed")
+	}
+	return checkMAC(expected, hash, secret, body)
+}
+
+// checkMAC checks that expected is the HMAC of body using hash and secret.
+func checkMAC(expected []byte, hash func() hash.Hash, secret string, body []byte) error {
+	mac := hmac.New(hash, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return errors.New("could not verify request signature. The signature is invalid")
+	}
+	return nil
+}
+
+// checkToken checks that token matches secret in constant time.
+func checkToken(token, secret string) error {
+	if !hmac.Equal([]byte(token), []byte(secret)) {
+		return errors.New("unable to verify request. The token and specified secret do not match")
+	}
+	return nil
+}
+
+// hookAcceptedError is returned when a webhook started a job
+// to pull in background.
+type hookAcceptedError struct {
+	job HookJob
+	url string // url of the job status
+}
+
+// Error satisfies error interface
+func (h hookAcceptedError) Error() string {
+	return fmt.Sprintf("webhook accepted as job %v", h.job.ID)
+}
+
+// hookPull pulls repo in response to a webhook. Only a pull cancelled
+// by a before command is reported back to the hook, other pull errors
+// are returned as hookPullError, which is reported only for webhooks
+// shared by several repositories, and logged as usual. In async mode,
+// the pull is started as a job. then, if not nil, is executed after
+// a successful pull.
+func hookPull(repo *Repo, then func() error) error {
+	pull := func(started func()) error {
+		if err := repo.pullFor(TriggerWebhook, started); err != nil || then == nil {
+			return err
+		}
+		return then()
+	}
+	if repo.Hook.Async {
+		return hookAsync(repo, pull)
+	}
+	err := pull(nil)
+	if err != nil && !pullCancelled(err) {
+		return hookPullError{err: err}
+	}
+	return err
+}
+
+// hookDispatch updates repo for the events parsed by handler h.
+// Deleted refs are ignored. In tag mode, pushed tags matching the
+// branch are checked out and branches are ignored. Otherwise, pushes
+// of the tracked branch are pulled and releases switch the branch
+// to the released tag. repo is pulled once if any event applies.
+func hookDispatch(h hookHandler, repo *Repo, events ...HookEvent) error {
+	return hookDispatchThen(h, repo, nil, events...)
+}
+
+// hookDispatchThen is hookDispatch executing then after the pull,
+// e.g. to check out the commit of the event.
+func hookDispatchThen(h hookHandler, repo *Repo, then func() error, events ...HookEvent) error {
+	var matched []HookEvent
+	var reason error = errors.New("the request contained no ref updates")
+	for _, event := range events {
+		if event.Kind == "" {
+			return errors.New("the push request contained an invalid reference string")
+		}
+		if err := hookCheckRepo(h, repo, event.Repo); err != nil {
+			return err
+		}
+		if err := hookMatch(repo, event); err != nil {
+			reason = err
+			continue
+		}
+		matched = append(matched, event)
+	}
+	if len(matched) == 0 {
+		return hookIgnoredError{hookType: hookName(h), err: reason}
+	}
+
+	event := matched[len(matched)-1]
+	switch {
+	case event.Kind == EventRelease && !repo.tagMode():
+		Logger().Printf("Received new release '%s'. -> Updating local repository to this release.\n", event.Tag())
+
+		// Update the local branch to the release tag name
+		// this will pull the release tag.
+		repo.Branch = event.Tag()
+	case event.Tag() != "":
+		Logger().Printf("Received tag %v, updating...\n", event.Tag())
+		repo.flight.setTag(event.Tag())
+	case event.Forced:
+		Logger().Printf("Received forced push by %v to the tracking branch, updating...\n", event.Pusher)
+	default:
+		Logger().Println("Received pull notification for the tracking branch, updating...")
+	}
+	return hookPull(repo, then)
+}
+
+// hookCheckRepo checks that the repository of a webhook of handler h
+// is repo or one of the repositories allowed by its hook. Repositories
+// without identity, e.g. of the generic hook, are not checked.
+func hookCheckRepo(h hookHandler, repo *Repo, hookRepo HookRepo) error {
+	if hookRepo.Name == "" && len(hookRepo.URLs) == 0 {
+		return nil
+	}
+	if hookRepo.matches(repo.URL.String()) {
+		return nil
+	}
+	for _, allowed := range repo.Hook.AllowRepos {
+		if hookRepo.matches(allowed) {
+			return nil
+		}
+	}
+	return hookRejectedError{hookType: hookName(h), repo: hookRepo}
+}
+
+// hookMatch returns the reason to ignore event for repo or nil
+// if repo is to be updated.
+func hookMatch(repo *Repo, event HookEvent) error {
+	switch {
+	case event.Deleted:
+		return fmt.Errorf("found deleted ref %v", event.Ref)
+	case event.Kind == EventRelease && !repo.tagMode():
+		return nil
+	case event.Tag() != "":
+		if !repo.tagMode() {
+			return fmt.Errorf("found tag %v", event.Tag())
+		}
+		if !repo.matchesTag(event.Tag()) {
+			return fmt.Errorf("found tag %v not matching %v", event.Tag(), repo.Branch)
+		}
+	case repo.tagMode() || event.Branch() != repo.Branch:
+		return fmt.Errorf("found different branch %v", event.Branch())
+	}
+	return nil
+}
+
+// hookAsync starts a job executing pull in background.
+func hookAsync(repo *Repo, pull func(started func()) error) error {
+	job, err := Jobs.start(repo, pull)
+	if err != nil {
+		return err
+	}
+	return hookAcceptedError{job: job, url: strings.TrimSuffix(repo.Hook.URL, "/") + jobsPath + job.ID}
+}
+
+// hookStatus adjusts the response status of a handled webhook.
+// Ignored webhooks are logged and allowed to continue while
+// cancelled pulls are reported as failed preconditions and
+// webhooks of other repositories as forbidden.
+// Started jobs are responded to as accepted.
+func hookStatus(w http.ResponseWriter, status int, err error) (int, error) {
+	if accepted, ok := err.(hookAcceptedError); ok {
+		w.Header().Set("Location", accepted.url)
+		return writeJSON(w, http.StatusAccepted, accepted.job)
+	}
+	switch {
+	case hookIgnored(err):
+		Logger().Println(err)
+		err = nil
+	case pullCancelled(err):
+		status = http.StatusPreconditionFailed
+	case hookPullFailed(err):
+		status, err = http.StatusOK, nil
+	case hookRejected(err):
+		status = http.StatusForbidden
+	}
+	return status, err
+}
+
+// serveHook handles the webhook request r for repo with handler
+// and responds with the adjusted status.
+func serveHook(w http.ResponseWriter, r *http.Request, repo *Repo, handler hookHandler) (int, error) {
+	status, err := handleHook(w, r, repo, handler)
+	return hookStatus(w, status, err)
+}
+
+// handleHook handles the webhook request r for repo with handler.
+// Requests from addresses not allowed by the hook and deliveries
+// with stale timestamps are rejected and repeated deliveries are
+// ignored.
+func handleHook(w http.ResponseWriter, r *http.Request, repo *Repo, handler hookHandler) (int, error) {
+	if !repo.Hook.AllowIPs.allows(r.RemoteAddr) {
+		return http.StatusForbidden, fmt.Errorf("the request from %v doesn't come from an allowed IP", hostOnly(r.RemoteAddr))
+	}
+
+	now := time.Now()
+	if err := checkTimestamp(r.Header, now); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	id := deliveryID(r.Header)
+	if id != "" {
+		id = repo.ID() + " " + id
+		if !Deliveries.add(id, now) {
+			return http.StatusOK, hookIgnoredError{hookType: hookName(handler), err: fmt.Errorf("repeated delivery %v", id)}
+		}
+	}
+
+	status, err := handler.Handle(w, r, repo)
+	// allow the provider to retry a failed delivery
+	if id != "" && hookFailed(status, err) {
+		Deliveries.remove(id)
+	}
+	return status, err
+}
+
+// hookFailed checks if a webhook handled with status and err failed.
+// Ignored webhooks and started jobs did not.
+func hookFailed(status int, err error) bool {
+	if _, ok := err.(hookAcceptedError); ok {
+		return false
+	}
+	return status >= 400 || (err != nil && !hookIgnored(err))
+}
+
+// handlerFor returns the handler of repo's hook type or, if not
+// specified, the first default handler that handles the request r.
+// It returns nil if there is none.
+func handlerFor(repo *Repo, r *http.Request) hookHandler {
+	if handler, ok := handlers[repo.Hook.Type]; ok {
+		if handler.DoesHandle(r.Header) {
+			return handler
+		}
+		return nil
+	}
+
+	// auto detect handler
+	var body []byte
+	for _, h := range defaultHandlers {
+		// providers without identifying headers are
+		// detected by the payload
+		if detector, ok := handlers[h].(payloadDetector); ok {
+			if body == nil {
+				body = peekBody(r)
+			}
+			if detector.DoesHandlePayload(body) {
+				return handlers[h]
+			}
+			continue
+		}
+
+		// if a handler indicates it does handle the request,
+		// we do not try other handlers. Only one handler ever
+		// handles a specific request.
+		if handlers[h].DoesHandle(r.Header) {
+			return handlers[h]
+		}
+	}
+	return nil
+}
+
+// peekBody returns the body of r and restores it to be read again.
+func peekBody(r *http.Request) []byte {
+	body := []byte{}
+	if r.Body != nil {
+		body, _ = ioutil.ReadAll(r.Body)
+		r.Body.Close()
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body
+}
+
+// serveJob responds with the status of the hook job with id of repo.
+// The webhook secret, if any, is required as bearer token.
+func serveJob(w http.ResponseWriter, r *http.Request, repo *Repo, id string) (int, error) {
+	if r.Method != "GET" {
+		return http.StatusMethodNotAllowed, errors.New("the request had an invalid method")
+	}
+	if repo.Hook.Secret != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !hmac.Equal([]byte(token), []byte(repo.Hook.Secret)) {
+			return http.StatusUnauthorized, errors.New("invalid or missing bearer token")
+		}
+	}
+	job, ok := Jobs.Get(id)
+	if !ok {
+		return http.StatusNotFound, fmt.Errorf("job %v not found", id)
+	}
+	return writeJSON(w, http.StatusOK, job)
+}
+
+// writeJSON writes v as JSON response with status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) (int, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+	return status, nil
+}
+
 // hookName returns the name of the hookHanlder h.
 func hookName(h hookHandler) string {
 	for name, handler := range handlers {
@@ -55,18 +420,27 @@ type hookHandler interface {
 	Handle(w http.ResponseWriter, r *http.Request, repo *Repo) (int, error)
 }
 
+// payloadDetector is implemented by hookHandlers of providers
+// that can only be detected by the payload.
+type payloadDetector interface {
+	DoesHandlePayload(body []byte) bool
+}
+
 // handlers stores all registered hookHandlers.
 // map key corresponds to expected config name.
 //
 // register hook handlers here.
 var handlers = map[string]hookHandler{
-	"github":    GithubHook{},
-	"gitlab":    GitlabHook{},
-	"bitbucket": BitbucketHook{},
-	"generic":   GenericHook{},
-	"travis":    TravisHook{},
-	"gogs":      GogsHook{},
-	"gitee":     GiteeHook{},
+	"github":           GithubHook{},
+	"gitlab":           GitlabHook{},
+	"bitbucket":        BitbucketHook{},
+	"generic":          GenericHook{},
+	"travis":           TravisHook{},
+	"gogs":             GogsHook{},
+	"gitee":            GiteeHook{},
+	"gitea":            GiteaHook{},
+	"azure":            AzureHook{},
+	"bitbucket-server": BitbucketServerHook{},
 }
 
 // defaultHandlers is the list of handlers to choose from
@@ -74,52 +448,51 @@ var handlers = map[string]hookHandler{
 var defaultHandlers = []string{
 	"github",
 	"gitlab",
+	"bitbucket-server",
 	"bitbucket",
 	"travis",
+	"gitea",
 	"gogs",
 	"gitee",
+	"azure",
 }
 
 // ServeHTTP implements the middlware.Handler interface.
+// A request to a hook url shared by several repos is handled
+// for each of them.
 func (h WebHook) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, error) {
-
+	var repos []*Repo
 	for _, repo := range h.Repos {
 
-		if r.URL.Path == repo.Hook.URL {
-
-			// if handler type is specified.
-			if handler, ok := handlers[repo.Hook.Type]; ok {
-				if !handler.DoesHandle(r.Header) {
-					return http.StatusBadRequest, errors.New(http.StatusText(http.StatusBadRequest))
-				}
-				status, err := handler.Handle(w, r, repo)
-				// if the webhook is ignored, log it and allow request to continue.
-				if hookIgnored(err) {
-					Logger().Println(err)
-					err = nil
-				}
-				return status, err
+		// status of hook jobs
+		prefix := strings.TrimSuffix(repo.Hook.URL, "/") + jobsPath
+		if repo.Hook.URL != "" && strings.HasPrefix(r.URL.Path, prefix) {
+			if id := strings.TrimPrefix(r.URL.Path, prefix); Jobs.owns(repo, id) {
+				return serveJob(w, r, repo, id)
 			}
+		}
 
-			// auto detect handler
-			for _, h := range defaultHandlers {
-				// if a handler indicates it does handle the request,
-				// we do not try other handlers. Only one handler ever
-				// handles a specific request.
-				if handlers[h].DoesHandle(r.Header) {
-					status, err := handlers[h].Handle(w, r, repo)
-					// if the webhook is ignored, log it and allow request to continue.
-					if hookIgnored(err) {
-						Logger().Println(err)
-						err = nil
-					}
-					return status, err
-				}
-			}
+		if r.URL.Path == repo.Hook.URL {
+			repos = append(repos, repo)
+		}
+	}
 
-			// no compatible handler
-			Logger().Println("No compatible handler found. Consider enabling generic handler with 'hook_type generic'.")
+	switch {
+	case len(repos) > 1:
+		return serveHooks(w, r, repos)
+	case len(repos) == 1:
+		repo := repos[0]
+		if handler := handlerFor(repo, r); handler != nil {
+			return serveHook(w, r, repo, handler)
 		}
+
+		// if handler type is specified.
+		if _, ok := handlers[repo.Hook.Type]; ok {
+			return http.StatusBadRequest, errors.New(http.StatusText(http.StatusBadRequest))
+		}
+
+		// no compatible handler
+		Logger().Println("No compatible handler found. Consider enabling generic handler with 'hook_type generic'.")
 	}
 
 	return h.Next.ServeHTTP(w, r)
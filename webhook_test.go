@@ -0,0 +1,104 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHookRequireAuth(t *testing.T) {
+	body := `{"ref": "refs/heads/other", "secret": "%v"}`
+	for i, test := range []struct {
+		handler hookHandler
+		headers map[string]string
+		secret  string
+		code    int
+	}{
+		{GithubHook{}, map[string]string{"X-Github-Event": "push"}, "", http.StatusUnauthorized},
+		{GitlabHook{}, map[string]string{"X-Gitlab-Event": "Push Hook"}, "", http.StatusUnauthorized},
+		{GitlabHook{}, map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "wrong"}, "", http.StatusUnauthorized},
+		{GitlabHook{}, map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "secret"}, "", http.StatusOK},
+		{GiteeHook{}, map[string]string{"X-Gitee-Event": "Push Hook"}, "", http.StatusUnauthorized},
+		{GiteeHook{}, map[string]string{"X-Gitee-Event": "Push Hook", "X-Gitee-Token": "secret"}, "", http.StatusOK},
+		{GogsHook{}, map[string]string{"X-Gogs-Event": "push"}, "", http.StatusUnauthorized},
+		{GogsHook{}, map[string]string{"X-Gogs-Event": "push"}, "wrong", http.StatusUnauthorized},
+		{GogsHook{}, map[string]string{"X-Gogs-Event": "push"}, "secret", http.StatusOK},
+		{GiteaHook{}, map[string]string{"X-Gitea-Event": "push"}, "", http.StatusUnauthorized},
+		{AzureHook{}, nil, "", http.StatusUnauthorized},
+		{BitbucketHook{}, map[string]string{"X-Event-Key": "repo:push"}, "", http.StatusUnauthorized},
+		{BitbucketServerHook{}, map[string]string{"X-Event-Key": "repo:refs_changed"}, "", http.StatusUnauthorized},
+		{GenericHook{}, nil, "", http.StatusUnauthorized},
+		{GenericHook{}, map[string]string{"Authorization": "Bearer wrong"}, "", http.StatusUnauthorized},
+		{GenericHook{}, map[string]string{"Authorization": "Bearer secret"}, "", http.StatusOK},
+		{TravisHook{}, map[string]string{"Travis-Repo-Slug": "user/repo"}, "", http.StatusUnauthorized},
+	} {
+		repo := &Repo{Branch: "master", Hook: HookConfig{URL: "/hook", Secret: "secret", RequireAuth: true}}
+		repo.Hook.AllowIPs.add("192.0.2.1")
+		req, err := http.NewRequest("POST", "/hook", bytes.NewBufferString(fmt.Sprintf(body, test.secret)))
+		if err != nil {
+			t.Fatalf("Test %v: Could not create HTTP request: %v", i, err)
+		}
+		req.RemoteAddr = "192.0.2.1:1234"
+		for k, v := range test.headers {
+			req.Header.Set(k, v)
+		}
+
+		code, _ := test.handler.Handle(httptest.NewRecorder(), req, repo)
+		if code != test.code {
+			t.Errorf("Test %v: Expected response code to be %v but was %v", i, test.code, code)
+		}
+	}
+}
+
+func TestHookDispatch(t *testing.T) {
+	release := newHookEvent("refs/tags/v1.3.0")
+	release.Kind = EventRelease
+	deleted := newHookEvent("refs/heads/master")
+	deleted.Deleted = true
+
+	for i, test := range []struct {
+		branch  string
+		events  []HookEvent
+		pulled  bool
+		tag     string
+		invalid bool
+	}{
+		{"master", []HookEvent{newHookEvent("refs/heads/master")}, true, "", false},
+		{"feature/x", []HookEvent{newHookEvent("refs/heads/feature/x")}, true, "", false},
+		{"master", []HookEvent{newHookEvent("refs/heads/other")}, false, "", false},
+		{"master", []HookEvent{newHookEvent("refs/tags/master")}, false, "", false},
+		{"master", []HookEvent{deleted}, false, "", false},
+		{"master", []HookEvent{newHookEvent("refs/heads/other"), newHookEvent("refs/heads/master")}, true, "", false},
+		{"master", nil, false, "", false},
+		{"{latest}", []HookEvent{newHookEvent("refs/tags/v1.2.0")}, true, "v1.2.0", false},
+		{"{latest}", []HookEvent{newHookEvent("refs/heads/master")}, false, "", false},
+		{"{latest}", []HookEvent{release}, true, "v1.3.0", false},
+		{"^1.2", []HookEvent{newHookEvent("refs/tags/v1.3.0")}, true, "v1.3.0", false},
+		{"^1.2", []HookEvent{newHookEvent("refs/tags/v2.0.0")}, false, "", false},
+		{"master", []HookEvent{newHookEvent("master")}, false, "", true},
+		{"master", []HookEvent{newHookEvent("refs/heads/")}, false, "", true},
+	} {
+		repo := &Repo{Branch: test.branch, stopped: true}
+		err := hookDispatch(GithubHook{}, repo, test.events...)
+		if test.invalid {
+			if err == nil || hookIgnored(err) {
+				t.Errorf("Test %v: Expected invalid ref error but found %v", i, err)
+			}
+			continue
+		}
+		if pulled := err == nil; pulled != test.pulled {
+			t.Errorf("Test %v: Expected pull %v but found %v", i, test.pulled, err)
+		}
+		if tag := repo.flight.takeTag(); tag != test.tag {
+			t.Errorf("Test %v: Expected tag %v but found %v", i, test.tag, tag)
+		}
+	}
+
+	// releases switch the branch outside of tag mode
+	repo := &Repo{Branch: "master", stopped: true}
+	if err := hookDispatch(GithubHook{}, repo, release); err != nil || repo.Branch != "v1.3.0" {
+		t.Errorf("Expected release to switch branch to v1.3.0, found %v %v", repo.Branch, err)
+	}
+}